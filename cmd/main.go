@@ -9,98 +9,92 @@ import (
 	"time"
 
 	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/db"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/dto"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/idempotency"
 	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/operation"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/orders"
 	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/rabbitmq"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/replay"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/repository"
 	"github.com/danielgtaylor/huma/v2"
 	"github.com/danielgtaylor/huma/v2/adapters/humachi"
 	"github.com/danielgtaylor/huma/v2/humacli"
-	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
 	"github.com/joho/godotenv"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/spf13/cobra"
 	"gorm.io/gorm"
 )
 
 // Options for CLI
 type Options struct {
-	Port int `help:"Port to listen on" short:"p" default:"8081"`
+	Port    int  `help:"Port to listen on" short:"p" default:"8081"`
+	Migrate bool `help:"Apply pending migrations before starting" default:"false"`
 }
 
-var dbConn *gorm.DB
-
 func main() {
 	_ = godotenv.Load()
-	dbConn = db.Init()
-
-	// RabbitMQ setup
-	var conn *amqp.Connection
-	var ch *amqp.Channel
+	dbConn := db.Open()
+
+	// RabbitMQ setup, the connection is started along with the server
+	var rabbitConn *rabbitmq.ConnectionManager
+	var outboxRelay *rabbitmq.OutboxRelay
+	var deadLetters *rabbitmq.DeadLetters
+	var eventRouter *rabbitmq.EventRouter
+	var publisher operation.EventPublisher
+	projections := repository.NewGormProjectionRepository(dbConn)
 	disableRabbit := os.Getenv("DISABLE_RABBITMQ") == "true"
 
 	if !disableRabbit {
-		conn, ch = rabbitmq.Connect()
-		eventRouter := rabbitmq.SetupEventHandlers(dbConn)
-		go func() {
-			if _, err := rabbitmq.StartListening(ch, eventRouter); err != nil {
-				log.Fatalf("Failed to start event listener: %v", err)
-			}
-		}()
+		// The connection manager reconnects and restarts the listener when the broker goes away
+		rabbitConn = rabbitmq.NewConnectionManager(os.Getenv("RABBIT_DSN"))
+		eventRouter = rabbitmq.SetupEventHandlers(projections)
+		queueConfig := rabbitmq.QueueConfigFromEnv()
+		rabbitConn.AddConsumer(func(ch *amqp.Channel) error {
+			_, err := rabbitmq.StartListening(ch, eventRouter, queueConfig)
+			return err
+		})
+		deadLetters = rabbitmq.NewDeadLetters(rabbitConn, eventRouter)
+
+		// Customer events are written to the outbox and published by the relay
+		publisher = rabbitmq.NewOutboxPublisher(dbConn)
+		outboxRelay = rabbitmq.NewOutboxRelay(dbConn, rabbitConn)
 	} else {
-		log.Println("DISABLE_RABBITMQ=true, skipping RabbitMQ connection")
+		// Without a relay the outbox would only grow, customer events are dropped
+		log.Println("DISABLE_RABBITMQ=true, skipping RabbitMQ connection and customer events")
+		publisher = rabbitmq.NopPublisher{}
 	}
 
+	prometheus.MustRegister(httpRequests, httpRequestDuration)
+
 	// CLI & API setup
 	cli := humacli.New(func(hooks humacli.Hooks, options *Options) {
-		router := chi.NewMux()
-		router.Use(middleware.Logger)
-		router.Use(middleware.Recoverer)
-		router.Use(middleware.Compress(5))
-
-		// Prometheus metrics
-		httpRequests := prometheus.NewCounterVec(
-			prometheus.CounterOpts{
-				Name: "http_requests_total",
-				Help: "Total HTTP requests",
-			},
-			[]string{"path", "method", "status"},
-		)
-
-		httpRequestDuration := prometheus.NewHistogramVec(
-			prometheus.HistogramOpts{
-				Name:    "http_request_duration_seconds",
-				Help:    "HTTP request duration in seconds",
-				Buckets: prometheus.DefBuckets,
-			},
-			[]string{"path", "method"},
-		)
-
-		prometheus.MustRegister(httpRequests, httpRequestDuration)
-
-		prometheusMiddleware := func(next http.Handler) http.Handler {
-			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				start := time.Now()
-				rw := &statusRecorder{ResponseWriter: w, status: 200}
-				next.ServeHTTP(rw, r)
-				dur := time.Since(start).Seconds()
-				httpRequests.WithLabelValues(r.URL.Path, r.Method, fmt.Sprintf("%d", rw.status)).Inc()
-				httpRequestDuration.WithLabelValues(r.URL.Path, r.Method).Observe(dur)
-			})
-		}
+		// Background work started by the server stops along with it
+		serverCtx, stopServer := context.WithCancel(context.Background())
 
-		router.Use(prometheusMiddleware)
-		router.Handle("/metrics", promhttp.Handler())
+		// Idempotency-Key support for retried POST requests
+		idempotencyStore := idempotency.NewGormStore(dbConn, idempotency.LeaseFromEnv())
+		idempotencyTTL := idempotency.TTLFromEnv()
+		router := newRouter(idempotencyStore, idempotencyTTL)
+		idempotency.StartPurge(serverCtx, idempotencyStore, idempotencyTTL, time.Hour)
 
 		// Huma API
 		configs := huma.DefaultConfig("Paye Ton Kawa - Customers", "1.0.0")
 		api := humachi.New(router, configs)
-		operation.RegisterCustomerRoutes(api, dbConn, ch)
-
-		// Debug endpoint
-		router.HandleFunc("/debug/500", func(w http.ResponseWriter, r *http.Request) {
-			http.Error(w, "debug 500", http.StatusInternalServerError)
-		})
+		customerService := operation.NewCustomerService(
+			repository.NewGormCustomerRepository(dbConn),
+			publisher,
+			orders.NewClient(orders.ConfigFromEnv()),
+			time.Now,
+			operation.NewEventID,
+		)
+		operation.RegisterCustomerRoutes(api, customerService)
+		if !disableRabbit {
+			operation.RegisterReplayRoutes(serverCtx, api, newReplayer(dbConn, publisher))
+		}
+		if deadLetters != nil {
+			operation.RegisterDeadLetterRoutes(api, deadLetters)
+		}
 
 		// HTTP server
 		server := &http.Server{
@@ -110,6 +104,27 @@ func main() {
 
 		// OnStart: blocking ListenAndServe
 		hooks.OnStart(func() {
+			// Opt-in for deployments without a separate migrate up step, replicas
+			// starting together wait for each other
+			if options.Migrate {
+				if err := migrateSchema(dbConn); err != nil {
+					log.Fatalf("Migration failed: %v", err)
+				}
+			}
+			// Neither serve requests nor consume events with a schema the code does not expect
+			if err := checkSchema(dbConn); err != nil {
+				log.Fatalf("Refusing to start: %v", err)
+			}
+			if err := dto.CheckCustomerCountry(); err != nil {
+				log.Fatalf("Refusing to start: %v", err)
+			}
+			if rabbitConn != nil {
+				rabbitConn.Start(serverCtx)
+				outboxRelay.Start(serverCtx)
+				rabbitmq.SampleQueueDepth(serverCtx, rabbitConn, eventRouter)
+				rabbitmq.StartInboxPurge(serverCtx, projections, rabbitmq.InboxRetentionFromEnv(), time.Hour)
+			}
+
 			log.Printf("Starting server on port %d...", options.Port)
 			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 				log.Fatalf("HTTP server failed: %v", err)
@@ -123,26 +138,68 @@ func main() {
 			if err := server.Shutdown(ctx); err != nil {
 				log.Printf("Server shutdown error: %v", err)
 			}
-			if conn != nil {
-				_ = conn.Close()
-			}
-			if ch != nil {
-				_ = ch.Close()
+			stopServer()
+			if rabbitConn != nil {
+				rabbitConn.Close()
 			}
 		})
 	})
 
+	// Republish existing customers for services joining late
+	replayOptions := replay.DefaultOptions()
+	replayCmd := &cobra.Command{
+		Use:   "replay-customers",
+		Short: "Republish every customer as a customer.snapshot event",
+		Long: "Streams customers from Postgres in batches and enqueues a customer.snapshot event for each one.\n" +
+			"Progress is checkpointed, an interrupted replay resumes where it stopped unless --restart is given.",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := checkSchema(dbConn); err != nil {
+				log.Fatalf("Replay failed: %v", err)
+			}
+			if disableRabbit && !replayOptions.DryRun {
+				log.Fatalf("Replay failed: DISABLE_RABBITMQ=true, snapshots would be dropped")
+			}
+			result, err := newReplayer(dbConn, publisher).Run(cmd.Context(), replayOptions)
+			if err != nil {
+				log.Fatalf("Replay failed: %v", err)
+			}
+			fmt.Printf("Replayed %d customers up to %d in %s (dry run: %t)\n",
+				result.Published, result.LastCustomerID, result.Duration, result.DryRun)
+		},
+	}
+	replayCmd.Flags().StringVar(&replayOptions.Name, "name", replayOptions.Name, "checkpoint name")
+	replayCmd.Flags().IntVar(&replayOptions.BatchSize, "batch-size", replayOptions.BatchSize, "customers per batch")
+	replayCmd.Flags().Float64Var(&replayOptions.Rate, "rate", replayOptions.Rate, "maximum events per second, 0 for no limit")
+	replayCmd.Flags().BoolVar(&replayOptions.Restart, "restart", false, "ignore the checkpoint and replay every customer")
+	replayCmd.Flags().BoolVar(&replayOptions.DryRun, "dry-run", false, "only count the customers that would be replayed")
+	cli.Root().AddCommand(replayCmd)
+
+	// Apply and revert the database migrations
+	cli.Root().AddCommand(newMigrateCommand(dbConn))
+
 	// Run CLI (starts server and blocks)
 	cli.Run()
 }
 
-// statusRecorder to capture HTTP status codes
-type statusRecorder struct {
-	http.ResponseWriter
-	status int
+// newReplayer returns a replayer publishing customer snapshots with publisher
+func newReplayer(dbConn *gorm.DB, publisher replay.Publisher) *replay.Replayer {
+	return replay.NewReplayer(dbConn, publisher, time.Now, operation.NewEventID)
 }
 
-func (r *statusRecorder) WriteHeader(code int) {
-	r.status = code
-	r.ResponseWriter.WriteHeader(code)
+// migrateSchema applies every pending migration
+func migrateSchema(dbConn *gorm.DB) error {
+	migrator, err := db.NewMigrator(dbConn)
+	if err != nil {
+		return err
+	}
+	return migrator.Up(context.Background())
+}
+
+// checkSchema fails when the database schema is behind the migrations of the binary
+func checkSchema(dbConn *gorm.DB) error {
+	migrator, err := db.NewMigrator(dbConn)
+	if err != nil {
+		return err
+	}
+	return migrator.CheckSchema(context.Background())
 }
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/db"
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+)
+
+// newMigrateCommand returns the migrate command managing the database schema
+func newMigrateCommand(dbConn *gorm.DB) *cobra.Command {
+	// withMigrator runs fn with a migrator and exits on failure
+	withMigrator := func(fn func(cmd *cobra.Command, args []string, migrator *db.Migrator) error) func(*cobra.Command, []string) {
+		return func(cmd *cobra.Command, args []string) {
+			migrator, err := db.NewMigrator(dbConn)
+			if err == nil {
+				err = fn(cmd, args, migrator)
+			}
+			if err != nil {
+				log.Fatalf("Migration failed: %v", err)
+			}
+		}
+	}
+
+	migrateCmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Manage the database schema",
+		Long: "Applies and reverts the versioned SQL migrations embedded in the binary.\n" +
+			"Replicas migrating at the same time wait for each other, the server refuses to start while migrations are pending.",
+	}
+
+	migrateCmd.AddCommand(&cobra.Command{
+		Use:   "up",
+		Short: "Apply every pending migration",
+		Args:  cobra.NoArgs,
+		Run: withMigrator(func(cmd *cobra.Command, args []string, migrator *db.Migrator) error {
+			return migrator.Up(cmd.Context())
+		}),
+	})
+
+	migrateCmd.AddCommand(&cobra.Command{
+		Use:   "down",
+		Short: "Revert the last applied migration",
+		Args:  cobra.NoArgs,
+		Run: withMigrator(func(cmd *cobra.Command, args []string, migrator *db.Migrator) error {
+			return migrator.Down(cmd.Context())
+		}),
+	})
+
+	migrateCmd.AddCommand(&cobra.Command{
+		Use:   "to <version>",
+		Short: "Apply or revert migrations until the given version, 0 reverting them all",
+		Args:  cobra.ExactArgs(1),
+		Run: withMigrator(func(cmd *cobra.Command, args []string, migrator *db.Migrator) error {
+			version, err := strconv.Atoi(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid version %q", args[0])
+			}
+			return migrator.To(cmd.Context(), version)
+		}),
+	})
+
+	migrateCmd.AddCommand(&cobra.Command{
+		Use:   "status",
+		Short: "List the migrations and whether they are applied",
+		Args:  cobra.NoArgs,
+		Run: withMigrator(func(cmd *cobra.Command, args []string, migrator *db.Migrator) error {
+			statuses, err := migrator.Status(cmd.Context())
+			if err != nil {
+				return err
+			}
+			for _, status := range statuses {
+				state := "pending"
+				switch {
+				case status.AppliedAt != nil && status.Up == "":
+					state = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05") + " (unknown to this binary)"
+				case status.AppliedAt != nil:
+					state = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+				}
+				fmt.Printf("%04d %-30s %s\n", status.Version, status.Name, state)
+			}
+			return nil
+		}),
+	})
+
+	return migrateCmd
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/idempotency"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prometheus metrics
+var (
+	httpRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "Total HTTP requests",
+		},
+		[]string{"path", "method", "status"},
+	)
+
+	httpRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "HTTP request duration in seconds",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"path", "method"},
+	)
+)
+
+// newRouter returns the router serving the API, its middlewares all registered before
+// the first route as chi requires
+func newRouter(idempotencyStore idempotency.Store, idempotencyTTL time.Duration) *chi.Mux {
+	router := chi.NewMux()
+	router.Use(middleware.RequestID)
+	router.Use(middleware.Logger)
+	router.Use(middleware.Recoverer)
+	router.Use(middleware.Compress(5))
+
+	prometheusMiddleware := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rw := &statusRecorder{ResponseWriter: w, status: 200}
+			next.ServeHTTP(rw, r)
+			dur := time.Since(start).Seconds()
+			httpRequests.WithLabelValues(r.URL.Path, r.Method, fmt.Sprintf("%d", rw.status)).Inc()
+			httpRequestDuration.WithLabelValues(r.URL.Path, r.Method).Observe(dur)
+		})
+	}
+	router.Use(prometheusMiddleware)
+
+	// Idempotency-Key support for retried POST requests
+	router.Use(idempotency.Middleware(idempotencyStore, idempotencyTTL))
+
+	router.Handle("/metrics", promhttp.Handler())
+
+	// Debug endpoint
+	router.HandleFunc("/debug/500", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "debug 500", http.StatusInternalServerError)
+	})
+
+	return router
+}
+
+// statusRecorder to capture HTTP status codes
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	localModels "github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/models"
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+)
+
+// claimStore is an idempotency.Store counting the claims, which it all grants
+type claimStore struct {
+	claims int
+}
+
+func (s *claimStore) Claim(ctx context.Context, key, hash string, ttl time.Duration) (*localModels.IdempotencyKey, bool, error) {
+	s.claims++
+	return nil, true, nil
+}
+
+func (s *claimStore) Complete(ctx context.Context, record *localModels.IdempotencyKey) error {
+	return nil
+}
+
+func (s *claimStore) Release(ctx context.Context, key string) error {
+	return nil
+}
+
+func (s *claimStore) PurgeExpired(ctx context.Context, ttl time.Duration) (int64, error) {
+	return 0, nil
+}
+
+func TestNewRouterServesAPIBehindIdempotency(t *testing.T) {
+	store := &claimStore{}
+	router := newRouter(store, time.Hour)
+
+	api := humachi.New(router, huma.DefaultConfig("Test", "1.0.0"))
+	huma.Post(api, "/things", func(ctx context.Context, input *struct{}) (*struct{}, error) {
+		return nil, nil
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/things", strings.NewReader("{}"))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", "key-1")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if store.claims != 1 {
+		t.Errorf("expected the idempotency key to be claimed once, got %d", store.claims)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected /metrics to answer 200, got %d", rec.Code)
+	}
+}
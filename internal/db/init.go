@@ -6,12 +6,11 @@ import (
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
-
-	"github.com/PayeTonKawa-EPSI-2025/Common-V2/models"
-	localModels "github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/models"
 )
 
-func Init() *gorm.DB {
+// Open connects to the database at DATABASE_DSN. The schema is managed by the
+// migrations, see Migrator.
+func Open() *gorm.DB {
 	dsn := os.Getenv("DATABASE_DSN")
 
 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
@@ -19,7 +18,5 @@ func Init() *gorm.DB {
 		log.Fatal("failed to connect to database:", err)
 	}
 
-	db.AutoMigrate(&models.Customer{}, &localModels.Order{}, &localModels.Product{}, &localModels.CustomerOrder{})
-
 	return db
 }
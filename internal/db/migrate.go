@@ -0,0 +1,359 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationsLock names the advisory lock held while migrating, so that replicas starting
+// together wait for the first one and then find nothing left to do
+const migrationsLock = "customers.schema_migrations"
+
+// noTransactionDirective starts the migrations that cannot run in a transaction, such as
+// CREATE INDEX CONCURRENTLY. Such migrations must hold a single statement.
+const noTransactionDirective = "-- migrate: no-transaction"
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// ErrSchemaBehind is returned by CheckSchema when migrations of the binary are not applied
+var ErrSchemaBehind = errors.New("database schema is behind, run the migrate up command")
+
+// Migration is a versioned schema change along with the statements reverting it
+type Migration struct {
+	Version       int
+	Name          string
+	Up            string
+	Down          string
+	NoTransaction bool
+}
+
+// MigrationStatus tells whether a migration is applied. Migrations applied by a newer
+// binary are listed too, with an empty Up and Down.
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Migrator applies and reverts the migrations embedded in the binary. Applied versions
+// are recorded in the schema_migrations table.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// NewMigrator creates a migrator for the embedded migrations
+func NewMigrator(db *gorm.DB) (*Migrator, error) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := embeddedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: sqlDB, migrations: migrations}, nil
+}
+
+// embeddedMigrations returns the migrations embedded in the binary
+func embeddedMigrations() ([]Migration, error) {
+	files, err := fs.Sub(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	return loadMigrations(files)
+}
+
+// loadMigrations reads the NNNN_name.up.sql and NNNN_name.down.sql files of fsys,
+// ordered by version. Every migration must come with its down file.
+func loadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %s", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(content)
+			migration.NoTransaction = strings.HasPrefix(migration.Up, noTransactionDirective)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d must have both an up and a down file", migration.Version)
+		}
+		migrations = append(migrations, *migration)
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int { return a.Version - b.Version })
+	return migrations, nil
+}
+
+// querier is implemented by both *sql.DB and *sql.Conn
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// appliedMigrations returns the applied migrations by version, none when the
+// schema_migrations table does not exist yet
+func appliedMigrations(ctx context.Context, q querier) (map[int]MigrationStatus, error) {
+	var table sql.NullString
+	if err := q.QueryRowContext(ctx, `SELECT to_regclass('schema_migrations')::text`).Scan(&table); err != nil {
+		return nil, err
+	}
+	applied := map[int]MigrationStatus{}
+	if !table.Valid {
+		return applied, nil
+	}
+
+	rows, err := q.QueryContext(ctx, `SELECT version, name, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status MigrationStatus
+		var appliedAt time.Time
+		if err := rows.Scan(&status.Version, &status.Name, &appliedAt); err != nil {
+			return nil, err
+		}
+		status.AppliedAt = &appliedAt
+		applied[status.Version] = status
+	}
+	return applied, rows.Err()
+}
+
+// Status lists the migrations of the binary and those applied by a newer one, by version
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := appliedMigrations(ctx, m.db)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := MigrationStatus{Migration: migration}
+		if record, ok := applied[migration.Version]; ok {
+			status.AppliedAt = record.AppliedAt
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, record := range applied {
+		statuses = append(statuses, record)
+	}
+	slices.SortFunc(statuses, func(a, b MigrationStatus) int { return a.Version - b.Version })
+	return statuses, nil
+}
+
+// CheckSchema fails with ErrSchemaBehind when migrations of the binary are not applied.
+// A schema ahead of the binary is accepted, so that older replicas keep running while a
+// newer version rolls out.
+func (m *Migrator) CheckSchema(ctx context.Context) error {
+	applied, err := appliedMigrations(ctx, m.db)
+	if err != nil {
+		return err
+	}
+
+	var pending []string
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; !ok {
+			pending = append(pending, migration.label())
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: pending %s", ErrSchemaBehind, strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+// Up applies every pending migration
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.locked(ctx, func(conn *sql.Conn, applied map[int]MigrationStatus) error {
+		return m.applyUpTo(ctx, conn, applied, m.latest())
+	})
+}
+
+// Down reverts the last applied migration
+func (m *Migrator) Down(ctx context.Context) error {
+	return m.locked(ctx, func(conn *sql.Conn, applied map[int]MigrationStatus) error {
+		if len(applied) == 0 {
+			log.Println("No migration to revert")
+			return nil
+		}
+		last := slices.Max(slices.Collect(maps.Keys(applied)))
+		return m.revertDownTo(ctx, conn, applied, last-1)
+	})
+}
+
+// To migrates the schema to the given version, applying the migrations up to it and
+// reverting those after it. Version 0 reverts every migration.
+func (m *Migrator) To(ctx context.Context, version int) error {
+	if version != 0 && !slices.ContainsFunc(m.migrations, func(migration Migration) bool {
+		return migration.Version == version
+	}) {
+		return fmt.Errorf("unknown migration version %d", version)
+	}
+	return m.locked(ctx, func(conn *sql.Conn, applied map[int]MigrationStatus) error {
+		if err := m.revertDownTo(ctx, conn, applied, version); err != nil {
+			return err
+		}
+		return m.applyUpTo(ctx, conn, applied, version)
+	})
+}
+
+// latest returns the version of the last migration of the binary
+func (m *Migrator) latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// locked runs fn on a connection holding the migrations lock, with the migrations
+// applied once the lock is acquired
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn, applied map[int]MigrationStatus) error) error {
+	// Advisory locks belong to a session, every statement must go through the same connection
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock(hashtext($1))`, migrationsLock); err != nil {
+		return err
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock(hashtext($1))`, migrationsLock); err != nil {
+			log.Printf("Error releasing the migrations lock: %v", err)
+		}
+	}()
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version bigint PRIMARY KEY,
+		name text NOT NULL,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return err
+	}
+
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return err
+	}
+	return fn(conn, applied)
+}
+
+// applyUpTo applies the pending migrations up to version, in order
+func (m *Migrator) applyUpTo(ctx context.Context, conn *sql.Conn, applied map[int]MigrationStatus, version int) error {
+	for _, migration := range m.migrations {
+		if migration.Version > version {
+			break
+		}
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		err := run(ctx, conn, migration.Up, migration.NoTransaction,
+			`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, migration.Version, migration.Name)
+		if err != nil {
+			return fmt.Errorf("applying migration %s: %w", migration.label(), err)
+		}
+		applied[migration.Version] = MigrationStatus{Migration: migration}
+		log.Printf("Applied migration %s", migration.label())
+	}
+	return nil
+}
+
+// revertDownTo reverts the applied migrations after version, the last one first
+func (m *Migrator) revertDownTo(ctx context.Context, conn *sql.Conn, applied map[int]MigrationStatus, version int) error {
+	for _, record := range slices.Backward(slices.Sorted(maps.Keys(applied))) {
+		if record <= version {
+			break
+		}
+		index := slices.IndexFunc(m.migrations, func(migration Migration) bool { return migration.Version == record })
+		if index < 0 {
+			return fmt.Errorf("migration %d was applied by a newer binary and cannot be reverted by this one", record)
+		}
+		migration := m.migrations[index]
+		err := run(ctx, conn, migration.Down, migration.NoTransaction,
+			`DELETE FROM schema_migrations WHERE version = $1`, migration.Version)
+		if err != nil {
+			return fmt.Errorf("reverting migration %s: %w", migration.label(), err)
+		}
+		delete(applied, migration.Version)
+		log.Printf("Reverted migration %s", migration.label())
+	}
+	return nil
+}
+
+// run executes the statements of a migration then records it with the given statement,
+// in a single transaction unless noTransaction is set
+func run(ctx context.Context, conn *sql.Conn, statements string, noTransaction bool, record string, args ...any) error {
+	if noTransaction {
+		// A failure between both statements leaves the migration unrecorded, its
+		// statements are expected to be idempotent (IF NOT EXISTS, IF EXISTS)
+		if _, err := conn.ExecContext(ctx, statements); err != nil {
+			return err
+		}
+		_, err := conn.ExecContext(ctx, record, args...)
+		return err
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, statements); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// label names a migration as its files do, e.g. 0002_customer_search
+func (m Migration) label() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
@@ -0,0 +1,186 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var testMigrations = fstest.MapFS{
+	"0001_create_things.up.sql":   {Data: []byte("CREATE TABLE things (id bigint)")},
+	"0001_create_things.down.sql": {Data: []byte("DROP TABLE things")},
+	"0002_index_things.up.sql":    {Data: []byte(noTransactionDirective + "\nCREATE INDEX CONCURRENTLY idx_things ON things (id)")},
+	"0002_index_things.down.sql":  {Data: []byte("DROP INDEX idx_things")},
+}
+
+func setupMockMigrator(t *testing.T) (*Migrator, sqlmock.Sqlmock) {
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	migrations, err := loadMigrations(testMigrations)
+	if err != nil {
+		t.Fatalf("failed to load migrations: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("unmet expectations: %v", err)
+		}
+	})
+	return &Migrator{db: sqlDB, migrations: migrations}, mock
+}
+
+// expectApplied expects the applied migrations to be read
+func expectApplied(mock sqlmock.Sqlmock, versions ...int) {
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT to_regclass('schema_migrations')::text`)).
+		WillReturnRows(sqlmock.NewRows([]string{"to_regclass"}).AddRow("schema_migrations"))
+	rows := sqlmock.NewRows([]string{"version", "name", "applied_at"})
+	for _, version := range versions {
+		rows.AddRow(version, "migration", time.Now())
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT version, name, applied_at FROM schema_migrations`)).WillReturnRows(rows)
+}
+
+// expectLock expects the migrations lock to be taken and the applied migrations to be read
+func expectLock(mock sqlmock.Sqlmock, versions ...int) {
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_lock(hashtext($1))`)).
+		WithArgs(migrationsLock).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`CREATE TABLE IF NOT EXISTS schema_migrations`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectApplied(mock, versions...)
+}
+
+func expectUnlock(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_unlock(hashtext($1))`)).
+		WithArgs(migrationsLock).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := embeddedMigrations()
+	if err != nil {
+		t.Fatalf("expected the embedded migrations to load, got %v", err)
+	}
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			t.Errorf("expected migration %d to have version %d, got %s", i, i+1, migration.label())
+		}
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations(testMigrations)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(migrations) != 2 || migrations[0].label() != "0001_create_things" || migrations[0].NoTransaction {
+		t.Fatalf("expected 0001_create_things in a transaction first, got %+v", migrations)
+	}
+	if !migrations[1].NoTransaction {
+		t.Error("expected 0002_index_things to run outside a transaction")
+	}
+
+	missingDown := fstest.MapFS{"0001_create_things.up.sql": {Data: []byte("CREATE TABLE things (id bigint)")}}
+	if _, err := loadMigrations(missingDown); err == nil {
+		t.Error("expected a migration without down file to be rejected")
+	}
+
+	badName := fstest.MapFS{"create_things.sql": {Data: []byte("CREATE TABLE things (id bigint)")}}
+	if _, err := loadMigrations(badName); err == nil {
+		t.Error("expected a badly named file to be rejected")
+	}
+}
+
+func TestUp(t *testing.T) {
+	m, mock := setupMockMigrator(t)
+
+	// 0001 is applied, 0002 runs outside a transaction
+	expectLock(mock, 1)
+	mock.ExpectExec(regexp.QuoteMeta(`CREATE INDEX CONCURRENTLY idx_things`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`)).
+		WithArgs(2, "index_things").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectUnlock(mock)
+
+	if err := m.Up(context.Background()); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func TestUpFailureRollsBack(t *testing.T) {
+	m, mock := setupMockMigrator(t)
+
+	failure := errors.New("syntax error")
+	expectLock(mock)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`CREATE TABLE things`)).WillReturnError(failure)
+	mock.ExpectRollback()
+	expectUnlock(mock)
+
+	if err := m.Up(context.Background()); !errors.Is(err, failure) {
+		t.Fatalf("expected the migration error, got %v", err)
+	}
+}
+
+func TestTo(t *testing.T) {
+	m, mock := setupMockMigrator(t)
+
+	// Going back to 0001 reverts 0002 only
+	expectLock(mock, 1, 2)
+	mock.ExpectExec(regexp.QuoteMeta(`DROP INDEX idx_things`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM schema_migrations WHERE version = $1`)).
+		WithArgs(2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectUnlock(mock)
+
+	if err := m.To(context.Background(), 1); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if err := m.To(context.Background(), 7); err == nil {
+		t.Error("expected an unknown version to be rejected")
+	}
+}
+
+func TestDownUnknownMigration(t *testing.T) {
+	m, mock := setupMockMigrator(t)
+
+	// 0003 was applied by a newer binary, which alone knows how to revert it
+	expectLock(mock, 1, 2, 3)
+	expectUnlock(mock)
+
+	if err := m.Down(context.Background()); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestCheckSchema(t *testing.T) {
+	m, mock := setupMockMigrator(t)
+
+	expectApplied(mock, 1)
+	if err := m.CheckSchema(context.Background()); !errors.Is(err, ErrSchemaBehind) {
+		t.Errorf("expected ErrSchemaBehind, got %v", err)
+	}
+
+	// A schema ahead of the binary is fine
+	expectApplied(mock, 1, 2, 3)
+	if err := m.CheckSchema(context.Background()); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+
+	// Nothing was ever migrated
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT to_regclass('schema_migrations')::text`)).
+		WillReturnRows(sqlmock.NewRows([]string{"to_regclass"}).AddRow(nil))
+	if err := m.CheckSchema(context.Background()); !errors.Is(err, ErrSchemaBehind) {
+		t.Errorf("expected ErrSchemaBehind, got %v", err)
+	}
+}
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS replay_checkpoints;
+DROP TABLE IF EXISTS processed_messages;
+DROP TABLE IF EXISTS outbox_events;
+DROP TABLE IF EXISTS idempotency_keys;
+DROP TABLE IF EXISTS customer_orders;
+DROP TABLE IF EXISTS products;
+DROP TABLE IF EXISTS orders;
+DROP TABLE IF EXISTS customers;
@@ -0,0 +1,100 @@
+-- Tables previously created by GORM AutoMigrate. Every statement is idempotent so that
+-- databases created by AutoMigrate are adopted as they are. Columns added to a table after
+-- it was first created are added again, as the table may come from an older version:
+-- customers.version, the order projection columns and outbox_events.correlation_id.
+
+CREATE TABLE IF NOT EXISTS customers (
+	id bigserial PRIMARY KEY,
+	created_at timestamptz,
+	updated_at timestamptz,
+	deleted_at timestamptz,
+	username text,
+	first_name text,
+	last_name text,
+	name text,
+	address_postal_code text,
+	address_city text,
+	profile_first_name text,
+	profile_last_name text,
+	company_company_name text,
+	version bigint NOT NULL DEFAULT 1
+);
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS version bigint NOT NULL DEFAULT 1;
+CREATE INDEX IF NOT EXISTS idx_customers_deleted_at ON customers (deleted_at);
+
+CREATE TABLE IF NOT EXISTS orders (
+	id bigserial PRIMARY KEY,
+	created_at timestamptz,
+	updated_at timestamptz,
+	deleted_at timestamptz,
+	customer_id bigint,
+	status varchar(50),
+	total numeric(12,2),
+	ordered_at timestamptz,
+	product_ids text
+);
+ALTER TABLE orders
+	ADD COLUMN IF NOT EXISTS customer_id bigint,
+	ADD COLUMN IF NOT EXISTS status varchar(50),
+	ADD COLUMN IF NOT EXISTS total numeric(12,2),
+	ADD COLUMN IF NOT EXISTS ordered_at timestamptz,
+	ADD COLUMN IF NOT EXISTS product_ids text;
+CREATE INDEX IF NOT EXISTS idx_orders_deleted_at ON orders (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_orders_customer_id ON orders (customer_id);
+CREATE INDEX IF NOT EXISTS idx_orders_ordered_at ON orders (ordered_at);
+
+CREATE TABLE IF NOT EXISTS products (
+	id bigserial PRIMARY KEY,
+	created_at timestamptz,
+	updated_at timestamptz,
+	deleted_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_products_deleted_at ON products (deleted_at);
+
+CREATE TABLE IF NOT EXISTS customer_orders (
+	id bigserial PRIMARY KEY,
+	customer_id bigint,
+	order_id bigint,
+	CONSTRAINT fk_customer_orders_customer FOREIGN KEY (customer_id) REFERENCES customers (id),
+	CONSTRAINT fk_customer_orders_order FOREIGN KEY (order_id) REFERENCES orders (id)
+);
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	key varchar(255) PRIMARY KEY,
+	request_hash varchar(64) NOT NULL,
+	status_code bigint,
+	headers text,
+	body bytea,
+	created_at timestamptz,
+	completed_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created_at ON idempotency_keys (created_at);
+
+CREATE TABLE IF NOT EXISTS outbox_events (
+	id bigserial PRIMARY KEY,
+	routing_key varchar(255) NOT NULL,
+	payload bytea NOT NULL,
+	created_at timestamptz NOT NULL,
+	sent_at timestamptz,
+	attempts bigint,
+	last_error text,
+	correlation_id varchar(255)
+);
+ALTER TABLE outbox_events ADD COLUMN IF NOT EXISTS correlation_id varchar(255);
+CREATE INDEX IF NOT EXISTS idx_outbox_events_sent_at ON outbox_events (sent_at);
+
+CREATE TABLE IF NOT EXISTS processed_messages (
+	message_id varchar(255),
+	handler varchar(100),
+	processed_at timestamptz NOT NULL,
+	PRIMARY KEY (message_id, handler)
+);
+CREATE INDEX IF NOT EXISTS idx_processed_messages_processed_at ON processed_messages (processed_at);
+
+CREATE TABLE IF NOT EXISTS replay_checkpoints (
+	name varchar(100) PRIMARY KEY,
+	last_customer_id bigint NOT NULL,
+	published bigint NOT NULL,
+	updated_at timestamptz,
+	completed_at timestamptz
+);
@@ -0,0 +1,4 @@
+-- The unaccent extension is kept, other schemas of the database may use it
+DROP INDEX IF EXISTS idx_customers_search_vector;
+ALTER TABLE customers DROP COLUMN IF EXISTS search_vector;
+DROP TEXT SEARCH CONFIGURATION IF EXISTS customers_search;
@@ -0,0 +1,25 @@
+-- Generated tsvector column and GIN index used by GET /customers/search.
+-- The customers_search configuration behaves like "simple" (no stemming, names are not
+-- words) but strips accents, so that "helene" matches "Hélène".
+
+CREATE EXTENSION IF NOT EXISTS unaccent;
+
+DO $$
+BEGIN
+	IF NOT EXISTS (SELECT 1 FROM pg_ts_config WHERE cfgname = 'customers_search') THEN
+		CREATE TEXT SEARCH CONFIGURATION customers_search (COPY = simple);
+		ALTER TEXT SEARCH CONFIGURATION customers_search
+			ALTER MAPPING FOR hword, hword_part, word WITH unaccent, simple;
+	END IF;
+END
+$$;
+
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS search_vector tsvector
+	GENERATED ALWAYS AS (
+		setweight(to_tsvector('customers_search', coalesce(username, '')), 'A') ||
+		setweight(to_tsvector('customers_search', coalesce(name, '')), 'A') ||
+		setweight(to_tsvector('customers_search', coalesce(company_company_name, '')), 'B') ||
+		setweight(to_tsvector('customers_search', coalesce(address_city, '')), 'C')
+	) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_customers_search_vector ON customers USING GIN (search_vector);
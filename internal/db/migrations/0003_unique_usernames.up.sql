@@ -0,0 +1,3 @@
+-- Usernames are case-insensitively unique among customers that are not deleted.
+-- Fails while duplicate usernames exist, which must then be fixed by hand.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_customers_username_lower ON customers (lower(username)) WHERE deleted_at IS NULL;
@@ -0,0 +1,4 @@
+-- Events that could not be routed after several attempts are parked with failed_at set,
+-- out of the way of the relay. Clearing failed_at sends them again.
+ALTER TABLE outbox_events ADD COLUMN IF NOT EXISTS failed_at timestamptz;
+CREATE INDEX IF NOT EXISTS idx_outbox_events_pending ON outbox_events (id) WHERE sent_at IS NULL AND failed_at IS NULL;
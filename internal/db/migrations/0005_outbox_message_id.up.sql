@@ -0,0 +1,3 @@
+-- Outbox events keep the ID of their payload, published as the CloudEvent id and AMQP
+-- message ID. Events stored before keep an ID derived from their row.
+ALTER TABLE outbox_events ADD COLUMN IF NOT EXISTS message_id varchar(255);
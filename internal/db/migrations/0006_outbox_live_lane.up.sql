@@ -0,0 +1,4 @@
+-- The relay reads live events before replay snapshots, this index finds them without
+-- scanning the snapshots a replay may have queued ahead of them.
+CREATE INDEX IF NOT EXISTS idx_outbox_events_pending_live ON outbox_events (id)
+	WHERE sent_at IS NULL AND failed_at IS NULL AND routing_key <> 'customer.snapshot';
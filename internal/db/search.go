@@ -0,0 +1,6 @@
+package db
+
+// SearchConfig is the Postgres text search configuration used for customer search.
+// It behaves like "simple" (no stemming, names are not words) but strips accents,
+// so that "helene" matches "Hélène". It is created by the customer_search migration.
+const SearchConfig = "customers_search"
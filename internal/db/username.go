@@ -0,0 +1,6 @@
+package db
+
+// UsernameIndex is the unique index making usernames case-insensitively unique among
+// customers that are not deleted. A deleted customer keeps its username until restored.
+// It is created by the unique_usernames migration.
+const UsernameIndex = "idx_customers_username_lower"
@@ -1,22 +1,59 @@
 package dto
 
-import "github.com/PayeTonKawa-EPSI-2025/Common-V2/models"
+import (
+	"time"
+
+	"github.com/PayeTonKawa-EPSI-2025/Common-V2/models"
+)
+
+type CustomersListInput struct {
+	Limit          int       `query:"limit" minimum:"1" maximum:"100" default:"20" doc:"Maximum number of customers to return"`
+	Cursor         string    `query:"cursor" doc:"Opaque cursor returned as next_cursor by a previous call"`
+	Sort           string    `query:"sort" enum:"username,-username,name,-name,created_at,-created_at" default:"created_at" doc:"Sort field, prefix with - for descending order"`
+	City           string    `query:"city" doc:"Only return customers living in this city"`
+	PostalCode     string    `query:"postal_code" doc:"Only return customers with this postal code"`
+	CompanyName    string    `query:"company_name" doc:"Only return customers working for this company"`
+	CreatedAfter   time.Time `query:"created_after" doc:"Only return customers created after this date (RFC 3339)"`
+	CreatedBefore  time.Time `query:"created_before" doc:"Only return customers created before this date (RFC 3339)"`
+	IncludeDeleted bool      `query:"include_deleted" doc:"Also return soft-deleted customers (admin only)"`
+	AdminToken     string    `header:"X-Admin-Token" doc:"Admin token, required to include deleted customers"`
+}
 
 type CustomersOutput struct {
+	Link string `header:"Link" doc:"RFC 8288 link to the next page"`
 	Body struct {
-		Customers []models.Customer `json:"customers"`
+		Customers  []models.Customer `json:"customers"`
+		Limit      int               `json:"limit"`
+		Cursor     string            `json:"cursor,omitempty"`
+		NextCursor string            `json:"next_cursor,omitempty"`
 	}
 }
 
+// ExpandedOrder is an order attached to a customer, with the fields the shared order
+// model lacks
+type ExpandedOrder struct {
+	models.Order
+	Status string  `json:"status,omitempty"`
+	Total  float64 `json:"total,omitempty"`
+}
+
+type CustomerBody struct {
+	models.Customer
+	// Orders shadows the orders of the shared customer model
+	Orders         []ExpandedOrder `json:"orders,omitempty" doc:"Orders of the customer, when expanded"`
+	OrdersDegraded bool            `json:"orders_degraded,omitempty" doc:"Set when the orders could not be fetched and may be missing or incomplete"`
+}
+
 type CustomerOutput struct {
-	Body models.Customer
+	ETag string `header:"ETag" doc:"Current version of the customer, to send back in If-Match"`
+	Body CustomerBody
 }
 
 type CustomerCreateBody struct {
-	Username  string         `json:"username"`
-	FirstName string         `json:"firstname"`
-	LastName  string         `json:"lastname"`
-	Address   models.Address `json:"address"`
+	Username  string         `json:"username" minLength:"3" maxLength:"50" pattern:"^[A-Za-z0-9][A-Za-z0-9._-]*$" patternDescription:"letters, digits, '.', '-' or '_', starting with a letter or digit"`
+	FirstName string         `json:"firstname" minLength:"1" maxLength:"100"`
+	LastName  string         `json:"lastname" minLength:"1" maxLength:"100"`
+	Address   models.Address `json:"address" doc:"The postal code must follow the format of CUSTOMER_COUNTRY (FR by default)"`
 	Company   models.Company `json:"company"`
 }
 
@@ -24,6 +61,20 @@ type CustomerCreateInput struct {
 	Body CustomerCreateBody `json:"body"`
 }
 
-type OrdersOutputBody struct {
-	Orders []models.Order `json:"orders"`
+type CustomerPatchInput struct {
+	Id          uint     `path:"id"`
+	IfMatch     []string `header:"If-Match" doc:"Only apply the patch if the customer still has this ETag"`
+	ContentType string   `header:"Content-Type"`
+	RawBody     []byte   `contentType:"application/merge-patch+json"`
+}
+
+type UsernameAvailabilityInput struct {
+	Username string `query:"username" required:"true" minLength:"3" maxLength:"50" pattern:"^[A-Za-z0-9][A-Za-z0-9._-]*$" patternDescription:"letters, digits, '.', '-' or '_', starting with a letter or digit"`
+}
+
+type UsernameAvailabilityOutput struct {
+	Body struct {
+		Username  string `json:"username"`
+		Available bool   `json:"available" doc:"False when another customer already uses this username, whatever its case"`
+	}
 }
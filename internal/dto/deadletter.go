@@ -0,0 +1,19 @@
+package dto
+
+import "github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/rabbitmq"
+
+type DeadLetterInput struct {
+	Id         string `path:"id" doc:"Dead letter ID"`
+	AdminToken string `header:"X-Admin-Token" doc:"Admin token"`
+}
+
+type DeadLettersOutput struct {
+	Body struct {
+		DeadLetters []rabbitmq.DeadLetter `json:"dead_letters"`
+		Total       int                   `json:"total" doc:"Number of messages in the dead-letter queue"`
+	}
+}
+
+type DeadLetterOutput struct {
+	Body rabbitmq.DeadLetter
+}
@@ -0,0 +1,31 @@
+package dto
+
+import "time"
+
+type CustomerOrdersInput struct {
+	Id            uint      `path:"id"`
+	Limit         int       `query:"limit" minimum:"1" maximum:"100" default:"20" doc:"Maximum number of orders to return"`
+	Cursor        string    `query:"cursor" doc:"Opaque cursor returned as next_cursor by a previous call"`
+	OrderedAfter  time.Time `query:"ordered_after" doc:"Only return orders placed after this date (RFC 3339)"`
+	OrderedBefore time.Time `query:"ordered_before" doc:"Only return orders placed before this date (RFC 3339)"`
+}
+
+type CustomerOrder struct {
+	ID         uint      `json:"id"`
+	CustomerID uint      `json:"customerId"`
+	Status     string    `json:"status,omitempty"`
+	Total      float64   `json:"total,omitempty"`
+	OrderedAt  time.Time `json:"orderedAt"`
+	ProductIDs []uint    `json:"productIds,omitempty"`
+}
+
+type CustomerOrdersOutput struct {
+	Link string `header:"Link" doc:"RFC 8288 link to the next page"`
+	Body struct {
+		Orders         []CustomerOrder `json:"orders"`
+		Limit          int             `json:"limit"`
+		Cursor         string          `json:"cursor,omitempty"`
+		NextCursor     string          `json:"next_cursor,omitempty"`
+		OrdersDegraded bool            `json:"orders_degraded,omitempty" doc:"Set when the Orders service could not be reached and orders may be missing"`
+	}
+}
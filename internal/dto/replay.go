@@ -0,0 +1,22 @@
+package dto
+
+import (
+	localModels "github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/models"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/replay"
+)
+
+type ReplayInput struct {
+	AdminToken string `header:"X-Admin-Token" doc:"Admin token"`
+	Body       replay.Options
+}
+
+type ReplayOutput struct {
+	Body replay.Options
+}
+
+type ReplayStatusOutput struct {
+	Body struct {
+		Running    bool                          `json:"running"`
+		Checkpoint *localModels.ReplayCheckpoint `json:"checkpoint,omitempty"`
+	}
+}
@@ -0,0 +1,20 @@
+package dto
+
+import "github.com/PayeTonKawa-EPSI-2025/Common-V2/models"
+
+type CustomerSearchInput struct {
+	Q     string `query:"q" required:"true" minLength:"1" maxLength:"200" doc:"Fragments of name, username, company or city"`
+	Limit int    `query:"limit" minimum:"1" maximum:"100" default:"20" doc:"Maximum number of results to return"`
+}
+
+type CustomerSearchResult struct {
+	Customer models.Customer `json:"customer"`
+	Rank     float32         `json:"rank" doc:"Relevance score, higher is better"`
+	Snippet  string          `json:"snippet" doc:"Matching text with hits wrapped in <mark> tags"`
+}
+
+type CustomerSearchOutput struct {
+	Body struct {
+		Results []CustomerSearchResult `json:"results"`
+	}
+}
@@ -0,0 +1,144 @@
+package dto
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/danielgtaylor/huma/v2"
+	"golang.org/x/text/unicode/norm"
+)
+
+// usernamePattern mirrors the pattern tag of CustomerCreateBody.Username
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// PostalCodeRules holds the postal code format of each supported country, keyed by
+// ISO 3166-1 alpha-2 code. Add an entry to support a new country.
+var PostalCodeRules = map[string]*regexp.Regexp{
+	"FR": regexp.MustCompile(`^(?:0[1-9]|[1-8]\d|9[0-8])\d{3}$`),
+	"BE": regexp.MustCompile(`^[1-9]\d{3}$`),
+	"CH": regexp.MustCompile(`^[1-9]\d{3}$`),
+	"LU": regexp.MustCompile(`^\d{4}$`),
+	"DE": regexp.MustCompile(`^\d{5}$`),
+}
+
+const (
+	maxCityLength        = 100
+	maxCompanyNameLength = 150
+)
+
+// customerCountry is the country whose postal code rule applies, CUSTOMER_COUNTRY or FR
+func customerCountry() string {
+	if country := strings.ToUpper(os.Getenv("CUSTOMER_COUNTRY")); country != "" {
+		return country
+	}
+	return "FR"
+}
+
+// CheckCustomerCountry fails when CUSTOMER_COUNTRY has no postal code rule, so that a
+// misconfigured country does not silently disable postal code validation
+func CheckCustomerCountry() error {
+	if country := customerCountry(); PostalCodeRules[country] == nil {
+		return fmt.Errorf("unsupported CUSTOMER_COUNTRY %q, expected one of %s",
+			country, strings.Join(slices.Sorted(maps.Keys(PostalCodeRules)), ", "))
+	}
+	return nil
+}
+
+// cleanText normalises a free text field: Unicode NFC, surrounding whitespace removed
+// and inner runs of whitespace collapsed to a single space
+func cleanText(s string) string {
+	return strings.Join(strings.Fields(norm.NFC.String(s)), " ")
+}
+
+// Normalize cleans every text field of the body. It must run before names are capitalised.
+// Usernames are left alone, their pattern rejects whitespace.
+func (b *CustomerCreateBody) Normalize() {
+	b.FirstName = cleanText(b.FirstName)
+	b.LastName = cleanText(b.LastName)
+	b.Address.City = cleanText(b.Address.City)
+	b.Address.PostalCode = strings.ReplaceAll(cleanText(b.Address.PostalCode), " ", "")
+	b.Company.CompanyName = cleanText(b.Company.CompanyName)
+}
+
+// Validate checks every constraint of a normalised body, including those declared in its
+// schema tags, and returns one error per invalid field. It is meant for bodies that did not
+// go through Huma request validation, such as a patched customer.
+func (b *CustomerCreateBody) Validate(prefix *huma.PathBuffer) []error {
+	v := validator{prefix: prefix}
+	if v.length("username", b.Username, 3, 50) && !usernamePattern.MatchString(b.Username) {
+		v.invalid("username", b.Username, "expected letters, digits, '.', '-' or '_', starting with a letter or digit")
+	}
+	v.length("firstname", b.FirstName, 1, 100)
+	v.length("lastname", b.LastName, 1, 100)
+	b.validateRules(&v)
+	return v.errs
+}
+
+// Resolve normalises the body once Huma has decoded it and checks the rules its schema
+// cannot express. Huma reports these errors together with the schema ones, so a single
+// problem details response lists every invalid field.
+func (b *CustomerCreateBody) Resolve(ctx huma.Context, prefix *huma.PathBuffer) []error {
+	v := validator{prefix: prefix}
+	// The schema accepts names made of whitespace only, they are empty once trimmed
+	blankFirstName := b.FirstName != "" && cleanText(b.FirstName) == ""
+	blankLastName := b.LastName != "" && cleanText(b.LastName) == ""
+
+	b.Normalize()
+	if blankFirstName {
+		v.invalid("firstname", b.FirstName, "expected a non-blank value")
+	}
+	if blankLastName {
+		v.invalid("lastname", b.LastName, "expected a non-blank value")
+	}
+	b.validateRules(&v)
+	return v.errs
+}
+
+// validateRules checks the address and company, whose types come from the shared models
+// and carry no schema constraints
+func (b *CustomerCreateBody) validateRules(v *validator) {
+	v.length("address.city", b.Address.City, 0, maxCityLength)
+	v.length("company.companyName", b.Company.CompanyName, 0, maxCompanyNameLength)
+
+	// The postal code is optional, but must follow the format of the country when set
+	if b.Address.PostalCode != "" {
+		// An unsupported country is refused at startup, reject rather than accept anything
+		country := customerCountry()
+		if rule := PostalCodeRules[country]; rule == nil || !rule.MatchString(b.Address.PostalCode) {
+			v.invalid("address.postalCode", b.Address.PostalCode, "expected a valid %s postal code", country)
+		}
+	}
+}
+
+// validator collects field errors located under a common prefix
+type validator struct {
+	prefix *huma.PathBuffer
+	errs   []error
+}
+
+func (v *validator) invalid(field string, value any, format string, args ...any) {
+	v.errs = append(v.errs, &huma.ErrorDetail{
+		Location: v.prefix.With(field),
+		Message:  fmt.Sprintf(format, args...),
+		Value:    value,
+	})
+}
+
+// length checks the length of value in characters and reports whether it is valid
+func (v *validator) length(field, value string, minLen, maxLen int) bool {
+	n := utf8.RuneCountInString(value)
+	switch {
+	case n < minLen:
+		v.invalid(field, value, "expected length >= %d", minLen)
+	case n > maxLen:
+		v.invalid(field, value, "expected length <= %d", maxLen)
+	default:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,226 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	localModels "github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/models"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// HeaderName is the request header carrying the client generated key
+const HeaderName = "Idempotency-Key"
+
+// DefaultTTL is how long a key is remembered when IDEMPOTENCY_TTL is not set
+const DefaultTTL = 24 * time.Hour
+
+// DefaultLease is how long a key stays claimed by a request that has not completed,
+// when IDEMPOTENCY_LEASE is not set
+const DefaultLease = time.Minute
+
+const (
+	maxKeyLength = 255
+	maxBodyBytes = 1024 * 1024
+)
+
+// ErrInProgress is returned when the first request using a key has not completed yet
+var ErrInProgress = errors.New("a request with this idempotency key is still being processed")
+
+// replayedHeaders are the response headers stored and sent back on replay
+var replayedHeaders = []string{"Content-Type", "ETag", "Location", "Link"}
+
+// TTLFromEnv reads the IDEMPOTENCY_TTL duration (e.g. "24h"), falling back to DefaultTTL
+func TTLFromEnv() time.Duration {
+	if value := os.Getenv("IDEMPOTENCY_TTL"); value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err == nil && ttl > 0 {
+			return ttl
+		}
+		log.Printf("Invalid IDEMPOTENCY_TTL %q, using %s", value, DefaultTTL)
+	}
+	return DefaultTTL
+}
+
+// LeaseFromEnv reads the IDEMPOTENCY_LEASE duration (e.g. "1m"), falling back to DefaultLease.
+// It must exceed the longest request, or a retry could run while the first request still does.
+func LeaseFromEnv() time.Duration {
+	if value := os.Getenv("IDEMPOTENCY_LEASE"); value != "" {
+		lease, err := time.ParseDuration(value)
+		if err == nil && lease > 0 {
+			return lease
+		}
+		log.Printf("Invalid IDEMPOTENCY_LEASE %q, using %s", value, DefaultLease)
+	}
+	return DefaultLease
+}
+
+// Middleware makes POST requests carrying an Idempotency-Key header safe to retry.
+// The first request runs normally and its response is stored; a retry with the same
+// key and body replays that response, while the same key with another body is rejected
+// with 422. Server errors release the key so the client can try again.
+func Middleware(store Store, ttl time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(HeaderName)
+			if key == "" || r.Method != http.MethodPost {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxKeyLength {
+				writeProblem(w, http.StatusBadRequest, "Idempotency-Key must be at most 255 characters")
+				return
+			}
+
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxBodyBytes+1))
+			if err != nil {
+				writeProblem(w, http.StatusBadRequest, "Unable to read request body")
+				return
+			}
+			if len(body) > maxBodyBytes {
+				writeProblem(w, http.StatusRequestEntityTooLarge, "Request body is too large")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			// The claim must survive a client that hangs up mid-request
+			ctx := context.WithoutCancel(r.Context())
+			hash := requestHash(r, body)
+
+			existing, claimed, err := store.Claim(ctx, key, hash, ttl)
+			if errors.Is(err, ErrInProgress) {
+				writeProblem(w, http.StatusConflict, ErrInProgress.Error())
+				return
+			}
+			if err != nil {
+				log.Printf("Error claiming idempotency key: %v", err)
+				writeProblem(w, http.StatusInternalServerError, "Unable to check the idempotency key")
+				return
+			}
+
+			if !claimed {
+				switch {
+				case existing.RequestHash != hash:
+					writeProblem(w, http.StatusUnprocessableEntity, "Idempotency-Key has already been used with a different request")
+				case existing.CompletedAt == nil:
+					writeProblem(w, http.StatusConflict, ErrInProgress.Error())
+				default:
+					replay(w, existing)
+				}
+				return
+			}
+
+			recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+			defer func() {
+				if p := recover(); p != nil {
+					_ = store.Release(ctx, key)
+					panic(p)
+				}
+			}()
+			next.ServeHTTP(recorder, r)
+
+			if recorder.status >= http.StatusInternalServerError {
+				if err := store.Release(ctx, key); err != nil {
+					log.Printf("Error releasing idempotency key: %v", err)
+				}
+				return
+			}
+
+			if err := store.Complete(ctx, recorder.record(key, hash)); err != nil {
+				log.Printf("Error storing idempotent response: %v", err)
+			}
+		})
+	}
+}
+
+// StartPurge deletes expired keys every interval until ctx is done
+func StartPurge(ctx context.Context, store Store, ttl, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if n, err := store.PurgeExpired(ctx, ttl); err != nil {
+					log.Printf("Error purging idempotency keys: %v", err)
+				} else if n > 0 {
+					log.Printf("Purged %d expired idempotency keys", n)
+				}
+			}
+		}
+	}()
+}
+
+// requestHash fingerprints the method, path and body of a request
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func replay(w http.ResponseWriter, record *localModels.IdempotencyKey) {
+	var headers http.Header
+	if record.Headers != "" {
+		_ = json.Unmarshal([]byte(record.Headers), &headers)
+	}
+	for name, values := range headers {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(record.StatusCode)
+	_, _ = w.Write(record.Body)
+}
+
+func writeProblem(w http.ResponseWriter, status int, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(huma.NewError(status, detail))
+}
+
+// responseRecorder forwards the response to the client while keeping a copy of it
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *responseRecorder) record(key, hash string) *localModels.IdempotencyKey {
+	headers := http.Header{}
+	for _, name := range replayedHeaders {
+		if values := r.Header().Values(name); len(values) > 0 {
+			headers[name] = values
+		}
+	}
+	encoded, _ := json.Marshal(headers)
+
+	return &localModels.IdempotencyKey{
+		Key:         key,
+		RequestHash: hash,
+		StatusCode:  r.status,
+		Headers:     string(encoded),
+		Body:        r.body.Bytes(),
+	}
+}
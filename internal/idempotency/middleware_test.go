@@ -0,0 +1,125 @@
+package idempotency_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/idempotency"
+	localModels "github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/models"
+)
+
+// memoryStore is an in-memory idempotency.Store for tests
+type memoryStore struct {
+	mu      sync.Mutex
+	records map[string]*localModels.IdempotencyKey
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{records: map[string]*localModels.IdempotencyKey{}}
+}
+
+func (s *memoryStore) Claim(ctx context.Context, key, hash string, ttl time.Duration) (*localModels.IdempotencyKey, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.records[key]; ok {
+		return existing, false, nil
+	}
+	s.records[key] = &localModels.IdempotencyKey{Key: key, RequestHash: hash}
+	return nil, true, nil
+}
+
+func (s *memoryStore) Complete(ctx context.Context, record *localModels.IdempotencyKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	record.CompletedAt = &now
+	s.records[record.Key] = record
+	return nil
+}
+
+func (s *memoryStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, key)
+	return nil
+}
+
+func (s *memoryStore) PurgeExpired(ctx context.Context, ttl time.Duration) (int64, error) {
+	return 0, nil
+}
+
+func newServer(status int) (http.Handler, *int) {
+	calls := 0
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`{"id":1}`))
+	})
+	return idempotency.Middleware(newMemoryStore(), time.Hour)(handler), &calls
+}
+
+func post(h http.Handler, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/customers", strings.NewReader(body))
+	req.Header.Set(idempotency.HeaderName, key)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMiddlewareReplaysResponse(t *testing.T) {
+	h, calls := newServer(http.StatusCreated)
+
+	first := post(h, "key-1", `{"username":"jdoe"}`)
+	second := post(h, "key-1", `{"username":"jdoe"}`)
+
+	if *calls != 1 {
+		t.Fatalf("expected handler to run once, ran %d times", *calls)
+	}
+
+	if second.Code != http.StatusCreated || second.Body.String() != first.Body.String() {
+		t.Errorf("expected replay of %d %q, got %d %q", first.Code, first.Body.String(), second.Code, second.Body.String())
+	}
+
+	if second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("expected Idempotent-Replayed header on replay")
+	}
+}
+
+func TestMiddlewareRejectsDifferentBody(t *testing.T) {
+	h, _ := newServer(http.StatusCreated)
+
+	post(h, "key-1", `{"username":"jdoe"}`)
+	rec := post(h, "key-1", `{"username":"asmith"}`)
+
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422, got %d", rec.Code)
+	}
+}
+
+func TestMiddlewareReleasesKeyOnServerError(t *testing.T) {
+	h, calls := newServer(http.StatusInternalServerError)
+
+	post(h, "key-1", `{"username":"jdoe"}`)
+	post(h, "key-1", `{"username":"jdoe"}`)
+
+	if *calls != 2 {
+		t.Errorf("expected handler to run again after a server error, ran %d times", *calls)
+	}
+}
+
+func TestMiddlewareIgnoresRequestsWithoutKey(t *testing.T) {
+	h, calls := newServer(http.StatusCreated)
+
+	post(h, "", `{"username":"jdoe"}`)
+	post(h, "", `{"username":"jdoe"}`)
+
+	if *calls != 2 {
+		t.Errorf("expected handler to run for every request without key, ran %d times", *calls)
+	}
+}
@@ -0,0 +1,91 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	localModels "github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Store persists idempotency records
+type Store interface {
+	// Claim reserves key for a request with the given hash. When the key is already
+	// taken by a live record, claimed is false and the existing record is returned.
+	// A claim that did not complete within its lease is abandoned and can be taken over.
+	Claim(ctx context.Context, key, hash string, ttl time.Duration) (existing *localModels.IdempotencyKey, claimed bool, err error)
+	// Complete stores the response of a claimed request
+	Complete(ctx context.Context, record *localModels.IdempotencyKey) error
+	// Release frees a claimed key so that the request can be retried
+	Release(ctx context.Context, key string) error
+	// PurgeExpired deletes the records older than ttl
+	PurgeExpired(ctx context.Context, ttl time.Duration) (int64, error)
+}
+
+// GormStore is the Postgres implementation of Store
+type GormStore struct {
+	db    *gorm.DB
+	lease time.Duration
+}
+
+// NewGormStore creates a store backed by the idempotency_keys table, in which requests
+// that crashed before completing free their key after lease
+func NewGormStore(db *gorm.DB, lease time.Duration) *GormStore {
+	return &GormStore{db: db, lease: lease}
+}
+
+func (s *GormStore) Claim(ctx context.Context, key, hash string, ttl time.Duration) (*localModels.IdempotencyKey, bool, error) {
+	db := s.db.WithContext(ctx)
+
+	// An expired record no longer protects its key, nor does a claim whose request
+	// crashed before completing. Records are created when claimed, created_at is the
+	// start of the lease.
+	now := time.Now()
+	if err := db.Where("key = ? AND (created_at < ? OR (completed_at IS NULL AND created_at < ?))",
+		key, now.Add(-ttl), now.Add(-s.lease)).
+		Delete(&localModels.IdempotencyKey{}).Error; err != nil {
+		return nil, false, err
+	}
+
+	record := localModels.IdempotencyKey{Key: key, RequestHash: hash}
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+	if result.Error != nil {
+		return nil, false, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return nil, true, nil
+	}
+
+	var existing localModels.IdempotencyKey
+	if err := db.First(&existing, "key = ?", key).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// Released between our insert and our read, let the client retry
+			return nil, false, ErrInProgress
+		}
+		return nil, false, err
+	}
+	return &existing, false, nil
+}
+
+func (s *GormStore) Complete(ctx context.Context, record *localModels.IdempotencyKey) error {
+	now := time.Now()
+	return s.db.WithContext(ctx).Model(&localModels.IdempotencyKey{}).
+		Where("key = ?", record.Key).
+		Updates(map[string]any{
+			"status_code":  record.StatusCode,
+			"headers":      record.Headers,
+			"body":         record.Body,
+			"completed_at": now,
+		}).Error
+}
+
+func (s *GormStore) Release(ctx context.Context, key string) error {
+	return s.db.WithContext(ctx).Where("key = ?", key).Delete(&localModels.IdempotencyKey{}).Error
+}
+
+func (s *GormStore) PurgeExpired(ctx context.Context, ttl time.Duration) (int64, error) {
+	result := s.db.WithContext(ctx).Where("created_at < ?", time.Now().Add(-ttl)).Delete(&localModels.IdempotencyKey{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,72 @@
+package idempotency_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/idempotency"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func setupMockStore(t *testing.T, lease time.Duration) (*idempotency.GormStore, sqlmock.Sqlmock) {
+	dbMock, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: dbMock,
+	}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open gorm DB: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("unfulfilled sqlmock expectations: %v", err)
+		}
+	})
+	return idempotency.NewGormStore(gormDB, lease), mock
+}
+
+// ago matches a time about age before now
+type ago struct{ age time.Duration }
+
+func (a ago) Match(v driver.Value) bool {
+	t, ok := v.(time.Time)
+	if !ok {
+		return false
+	}
+	drift := time.Since(t) - a.age
+	return drift >= 0 && drift < time.Second
+}
+
+func TestGormStoreClaimTakesOverAbandonedClaim(t *testing.T) {
+	store, mock := setupMockStore(t, time.Minute)
+
+	// The abandoned claim is deleted with the expired records, freeing the key
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(
+		`DELETE FROM "idempotency_keys" WHERE key = $1 AND (created_at < $2 OR (completed_at IS NULL AND created_at < $3))`,
+	)).
+		WithArgs("key-1", ago{time.Hour}, ago{time.Minute}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "idempotency_keys"`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	existing, claimed, err := store.Claim(context.Background(), "key-1", "hash", time.Hour)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !claimed || existing != nil {
+		t.Errorf("expected the key to be claimed, got claimed=%v existing=%+v", claimed, existing)
+	}
+}
@@ -0,0 +1,9 @@
+package models
+
+import "github.com/PayeTonKawa-EPSI-2025/Common-V2/models"
+
+// Customer is the shared customer model extended with the columns owned by this service
+type Customer struct {
+	models.Customer
+	Version uint `json:"-" gorm:"column:version;not null;default:1"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// IdempotencyKey stores the outcome of a request sent with an Idempotency-Key header,
+// so that retries of the same request replay the first response instead of running again
+type IdempotencyKey struct {
+	Key         string `gorm:"primaryKey;size:255"`
+	RequestHash string `gorm:"size:64;not null"`
+	StatusCode  int
+	Headers     string `gorm:"type:text"`
+	Body        []byte
+	CreatedAt   time.Time `gorm:"index"`
+	CompletedAt *time.Time
+}
@@ -1,7 +1,17 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"time"
 
+	"gorm.io/gorm"
+)
+
+// Order is the local projection of an order, maintained from order.* events
 type Order struct {
 	gorm.Model
+	CustomerID uint      `gorm:"index"`
+	Status     string    `gorm:"size:50"`
+	Total      float64   `gorm:"type:numeric(12,2)"`
+	OrderedAt  time.Time `gorm:"index"`
+	ProductIDs []uint    `gorm:"serializer:json;type:text"`
 }
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// OutboxEvent is an event waiting to be published to RabbitMQ. It is written in the
+// same transaction as the change it describes and published later by the outbox relay.
+type OutboxEvent struct {
+	ID         uint       `gorm:"primaryKey"`
+	RoutingKey string     `gorm:"size:255;not null"`
+	Payload    []byte     `gorm:"not null"`
+	CreatedAt  time.Time  `gorm:"not null"`
+	SentAt     *time.Time `gorm:"index"`
+	Attempts   int
+	LastError  string
+	// FailedAt is set when the event is given up on, it is no longer relayed
+	FailedAt *time.Time
+	// CorrelationID is the ID of the request that produced the event
+	CorrelationID string `gorm:"size:255"`
+	// MessageID is the ID of the event, published as its CloudEvent id and AMQP message ID
+	MessageID string `gorm:"size:255"`
+}
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// ProcessedMessage records that a handler already processed a consumed message.
+// It is written in the same transaction as the handler's changes so redeliveries are skipped.
+type ProcessedMessage struct {
+	MessageID   string    `gorm:"primaryKey;size:255"`
+	Handler     string    `gorm:"primaryKey;size:100"`
+	ProcessedAt time.Time `gorm:"not null;index"`
+}
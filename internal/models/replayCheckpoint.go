@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// ReplayCheckpoint remembers the last customer republished by a replay, so an interrupted
+// replay resumes where it stopped
+type ReplayCheckpoint struct {
+	Name           string `gorm:"primaryKey;size:100"`
+	LastCustomerID uint   `gorm:"not null"`
+	Published      int64  `gorm:"not null"`
+	UpdatedAt      time.Time
+	CompletedAt    *time.Time
+}
@@ -0,0 +1,28 @@
+package operation
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// AdminTokenHeader carries the shared secret granting access to admin-only operations
+const AdminTokenHeader = "X-Admin-Token"
+
+// requireAdmin checks the admin token sent by the caller against ADMIN_TOKEN.
+// Admin operations are disabled altogether when ADMIN_TOKEN is not set.
+func requireAdmin(token string) error {
+	expected := os.Getenv("ADMIN_TOKEN")
+	if expected == "" {
+		return huma.NewError(http.StatusForbidden, "Admin operations are disabled")
+	}
+	if token == "" {
+		return huma.NewError(http.StatusUnauthorized, "Missing "+AdminTokenHeader+" header")
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+		return huma.NewError(http.StatusForbidden, "Invalid admin token")
+	}
+	return nil
+}
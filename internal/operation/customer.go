@@ -2,187 +2,280 @@ package operation
 
 import (
 	"context"
-	"encoding/json"
-	"errors"
-	"fmt"
 	"net/http"
-	"os"
 	"strings"
 
 	"github.com/PayeTonKawa-EPSI-2025/Common-V2/events"
 	"github.com/PayeTonKawa-EPSI-2025/Common-V2/models"
 	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/dto"
-	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/rabbitmq"
+	localModels "github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/models"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/repository"
 	"github.com/danielgtaylor/huma/v2"
-	amqp "github.com/rabbitmq/amqp091-go"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
 	"gorm.io/gorm"
 )
 
-var httpGet = http.Get // default http.Get, can be overridden in tests
+var idempotencyKeyMaxLength = 255
 
 // ----------------------
 // Extracted CRUD Functions
 // ----------------------
 
-// Get all customers
-func GetCustomers(ctx context.Context, db *gorm.DB) (*dto.CustomersOutput, error) {
+// normalizeFirstName capitalises each word of a first name ("jean-marc" -> "Jean-Marc").
+// Names are trimmed and NFC normalised beforehand, see dto.CustomerCreateBody.Normalize.
+func normalizeFirstName(firstname string) string {
+	return cases.Title(language.English).String(firstname)
+}
+
+// normalizeLastName upper-cases a last name ("dupont" -> "DUPONT")
+func normalizeLastName(lastname string) string {
+	return strings.ToUpper(lastname)
+}
+
+// Get a page of customers, filtered and sorted
+func (s *CustomerService) GetCustomers(ctx context.Context, input *dto.CustomersListInput) (*dto.CustomersOutput, error) {
 	resp := &dto.CustomersOutput{}
 
-	var customers []models.Customer
-	results := db.Find(&customers)
+	if input.Limit <= 0 {
+		input.Limit = 20
+	}
+	if input.Sort == "" {
+		input.Sort = "created_at"
+	}
+
+	column, desc, err := parseSort(input.Sort)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := customerListFilter(input, column, desc)
+	if filter.After, err = cursorPosition(input, column); err != nil {
+		return nil, err
+	}
+
+	// Fetch one extra customer to know whether a next page exists
+	customers, err := s.repo.List(ctx, filter)
+	if err != nil {
+		return resp, err
+	}
 
-	if results.Error == nil {
-		resp.Body.Customers = customers
+	resp.Body.Limit = input.Limit
+	resp.Body.Cursor = input.Cursor
+
+	if len(customers) > input.Limit {
+		customers = customers[:input.Limit]
+		last := customers[len(customers)-1]
+		resp.Body.NextCursor = encodeCursor(listCursor{
+			Sort:  input.Sort,
+			Value: cursorValue(last, column),
+			ID:    last.ID,
+		})
+		resp.Link = nextPageLink(input, resp.Body.NextCursor)
 	}
 
-	return resp, results.Error
+	resp.Body.Customers = customers
+	return resp, nil
 }
 
-// Get a single customer by ID
-func GetCustomer(ctx context.Context, db *gorm.DB, id uint) (*dto.CustomerOutput, error) {
+// Get a single customer by ID, with its orders when expandOrders is set
+func (s *CustomerService) GetCustomer(ctx context.Context, id uint, ifNoneMatch []string, expandOrders bool) (*dto.CustomerOutput, error) {
 	resp := &dto.CustomerOutput{}
 
 	// 1️⃣ Fetch customer from local DB
-	var customer models.Customer
-	results := db.First(&customer, id)
-	if results.Error != nil {
-		if errors.Is(results.Error, gorm.ErrRecordNotFound) {
-			return nil, huma.NewError(http.StatusNotFound, "Customer not found")
-		}
-		return nil, results.Error
+	customer, err := s.repo.Get(ctx, id, false)
+	if err != nil {
+		return nil, repositoryError(err, nil)
 	}
 
-	// 2️⃣ Assign basic customer data
-	resp.Body = customer
-
-	// 3️⃣ Fetch orders from Orders service API
-	orders_url := os.Getenv("ORDERS_URL")
-	url := fmt.Sprintf("%s/orders/%d/customers", orders_url, customer.ID)
-	r, err := httpGet(url)
-	if err != nil {
-		fmt.Printf("Failed to fetch orders: %v\n", err)
-		return resp, nil // return customer without orders if API fails
+	// 2️⃣ Let caches revalidate. The ETag only covers the customer, so expanded
+	// responses are always sent in full as their orders may have changed. The 304
+	// still carries the ETag, which the cache keeps for its next revalidation.
+	resp.ETag = customerETag(customer.Version)
+	if !expandOrders && notModified(ifNoneMatch, resp.ETag) {
+		headers := http.Header{}
+		headers.Set("ETag", resp.ETag)
+		return nil, huma.ErrorWithHeaders(huma.Status304NotModified(), headers)
 	}
-	defer r.Body.Close()
-
-	if r.StatusCode == http.StatusOK {
-		var ordersResp dto.OrdersOutputBody
-		if err := json.NewDecoder(r.Body).Decode(&ordersResp); err != nil {
-			fmt.Printf("Failed to decode orders response: %v\n", err)
-		} else {
-			resp.Body.Orders = ordersResp.Orders
-		}
-	} else {
-		fmt.Printf("Orders API returned status %d\n", r.StatusCode)
+
+	// 3️⃣ Assign basic customer data
+	resp.Body.Customer = customer.Customer
+
+	// 4️⃣ Attach orders if asked, from the local projection or the Orders service
+	if expandOrders {
+		resp.Body.Orders, resp.Body.OrdersDegraded = s.customerOrders(ctx, customer.ID)
 	}
 
 	return resp, nil
 }
 
-// Create a new customer
-func CreateCustomer(ctx context.Context, db *gorm.DB, ch *amqp.Channel, input *dto.CustomerCreateInput) (*dto.CustomerOutput, error) {
-	resp := &dto.CustomerOutput{}
-
-	firstname := cases.Title(language.English).String(input.Body.FirstName)
-	lastname := strings.ToUpper(input.Body.LastName)
+// newCustomerFromBody builds the customer described by a create or replace body
+func newCustomerFromBody(body *dto.CustomerCreateBody) models.Customer {
+	body.Normalize()
+	firstname := normalizeFirstName(body.FirstName)
+	lastname := normalizeLastName(body.LastName)
 
-	customer := models.Customer{
-		Username:  input.Body.Username,
+	return models.Customer{
+		Username:  body.Username,
 		FirstName: firstname,
 		LastName:  lastname,
 		Name:      firstname + " " + lastname,
-		Address:   input.Body.Address,
+		Address:   body.Address,
 		Profile: models.Profile{
 			LastName:  lastname,
 			FirstName: firstname,
 		},
-		Company: input.Body.Company,
+		Company: body.Company,
 	}
+}
 
-	results := db.Create(&customer)
+// replaceColumns are the columns written when a customer is replaced
+var replaceColumns = []string{
+	"username", "first_name", "last_name", "name",
+	"address_postal_code", "address_city",
+	"profile_first_name", "profile_last_name",
+	"company_company_name",
+}
+
+// Create a new customer
+func (s *CustomerService) CreateCustomer(ctx context.Context, input *dto.CustomerCreateInput) (*dto.CustomerOutput, error) {
+	resp := &dto.CustomerOutput{}
 
-	if results.Error == nil {
-		resp.Body = customer
-		if ch != nil {
-			_ = rabbitmq.PublishCustomerEvent(ch, events.CustomerCreated, customer) // ignore publish error
+	customer := localModels.Customer{
+		Customer: newCustomerFromBody(&input.Body),
+		Version:  1,
+	}
+
+	// The event is stored along with the customer
+	err := s.repo.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.repo.Create(ctx, &customer); err != nil {
+			return err
 		}
+		return s.publish(ctx, events.CustomerCreated, customer.Customer, nil)
+	})
+	if err != nil {
+		return nil, repositoryError(err, nil)
 	}
 
-	return resp, results.Error
+	resp.ETag = customerETag(customer.Version)
+	resp.Body.Customer = customer.Customer
+	return resp, nil
 }
 
 // Update/replace a customer
-func UpdateCustomer(ctx context.Context, db *gorm.DB, ch *amqp.Channel, id uint, input dto.CustomerCreateInput) (*dto.CustomerOutput, error) {
+func (s *CustomerService) UpdateCustomer(ctx context.Context, id uint, ifMatch []string, input dto.CustomerCreateInput) (*dto.CustomerOutput, error) {
 	resp := &dto.CustomerOutput{}
 
-	var customer models.Customer
-	results := db.First(&customer, id)
+	customer, err := s.repo.Get(ctx, id, false)
+	if err != nil {
+		return nil, repositoryError(err, nil)
+	}
 
-	if errors.Is(results.Error, gorm.ErrRecordNotFound) {
-		return nil, huma.NewError(http.StatusNotFound, "Customer not found")
+	if err := checkIfMatch(ifMatch, customerETag(customer.Version)); err != nil {
+		return nil, err
 	}
-	if results.Error != nil {
-		return nil, results.Error
+
+	replacement := newCustomerFromBody(&input.Body)
+	replacement.Model = customer.Model
+	customer.Customer = replacement
+
+	// Only write if nobody updated the customer since we read it
+	err = s.repo.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.repo.Update(ctx, &customer, replaceColumns...); err != nil {
+			return err
+		}
+		return s.publish(ctx, events.CustomerUpdated, customer.Customer, nil)
+	})
+	if err != nil {
+		return nil, repositoryError(err, ifMatch)
 	}
 
-	firstname := cases.Title(language.English).String(input.Body.FirstName)
-	lastname := strings.ToUpper(input.Body.LastName)
+	resp.ETag = customerETag(customer.Version)
+	resp.Body.Customer = customer.Customer
+	return resp, nil
+}
 
-	updates := models.Customer{
-		Username:  input.Body.Username,
-		FirstName: firstname,
-		LastName:  lastname,
-		Name:      firstname + " " + lastname,
-		Address:   input.Body.Address,
-		Profile: models.Profile{
-			LastName:  lastname,
-			FirstName: firstname,
-		},
-		Company: input.Body.Company,
+// Delete a customer. Customers are soft-deleted and can be restored, unless purge is set,
+// in which case the customer and its order links are removed for good.
+func (s *CustomerService) DeleteCustomer(ctx context.Context, id uint, ifMatch []string, purge bool) error {
+	// Soft-deleted customers can be purged too
+	customer, err := s.repo.Get(ctx, id, purge)
+	if err != nil {
+		return repositoryError(err, nil)
 	}
 
-	results = db.Model(&customer).Updates(updates)
-	if results.Error != nil {
-		return nil, results.Error
+	if err := checkIfMatch(ifMatch, customerETag(customer.Version)); err != nil {
+		return err
 	}
 
-	// Reload updated customer
-	db.First(&customer, customer.ID)
-	resp.Body = customer
+	alreadyDeleted := customer.DeletedAt.Valid
+	err = s.repo.WithinTransaction(ctx, func(ctx context.Context) error {
+		opts := repository.DeleteOptions{Purge: purge, CheckVersion: len(ifMatch) > 0}
+		if err := s.repo.Delete(ctx, customer, opts); err != nil {
+			return err
+		}
 
-	if ch != nil {
-		_ = rabbitmq.PublishCustomerEvent(ch, events.CustomerUpdated, customer) // ignore publish error
+		// Only publish once per customer
+		if alreadyDeleted {
+			return nil
+		}
+		return s.publish(ctx, events.CustomerDeleted, customer.Customer, nil)
+	})
+	return repositoryError(err, ifMatch)
+}
+
+// Restore a soft-deleted customer
+func (s *CustomerService) RestoreCustomer(ctx context.Context, id uint, ifMatch []string) (*dto.CustomerOutput, error) {
+	resp := &dto.CustomerOutput{}
+
+	customer, err := s.repo.Get(ctx, id, true)
+	if err != nil {
+		return nil, repositoryError(err, nil)
 	}
 
-	return resp, nil
-}
+	if !customer.DeletedAt.Valid {
+		return nil, huma.NewError(http.StatusConflict, "Customer is not deleted")
+	}
 
-// Delete a customer
-func DeleteCustomer(ctx context.Context, db *gorm.DB, ch *amqp.Channel, id uint) error {
-	var customer models.Customer
-	result := db.First(&customer, id)
-	if result.Error != nil {
-		return result.Error
+	if err := checkIfMatch(ifMatch, customerETag(customer.Version)); err != nil {
+		return nil, err
 	}
 
-	results := db.Delete(&customer)
-	if results.Error == nil {
-		// Only publish if channel is not nil
-		if ch != nil {
-			_ = rabbitmq.PublishCustomerEvent(ch, events.CustomerDeleted, customer)
+	customer.DeletedAt = gorm.DeletedAt{}
+	err = s.repo.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.repo.Update(ctx, &customer, "deleted_at"); err != nil {
+			return err
 		}
-		return nil
+		return s.publish(ctx, events.CustomerUpdated, customer.Customer, nil)
+	})
+	if err != nil {
+		return nil, repositoryError(err, ifMatch)
+	}
+
+	resp.ETag = customerETag(customer.Version)
+	resp.Body.Customer = customer.Customer
+	return resp, nil
+}
+
+// Check whether a username is free. Usernames are compared case-insensitively, as
+// enforced by the unique username index.
+func (s *CustomerService) CheckUsernameAvailability(ctx context.Context, username string) (*dto.UsernameAvailabilityOutput, error) {
+	resp := &dto.UsernameAvailabilityOutput{}
+
+	taken, err := s.repo.UsernameTaken(ctx, username)
+	if err != nil {
+		return nil, err
 	}
 
-	return results.Error
+	resp.Body.Username = username
+	resp.Body.Available = !taken
+	return resp, nil
 }
 
 // ----------------------
 // Register routes with Huma
 // ----------------------
-func RegisterCustomerRoutes(api huma.API, dbConn *gorm.DB, ch *amqp.Channel) {
+func RegisterCustomerRoutes(api huma.API, service *CustomerService) {
 	// ----------------------
 	// Health endpoint
 	// ----------------------
@@ -202,12 +295,39 @@ func RegisterCustomerRoutes(api huma.API, dbConn *gorm.DB, ch *amqp.Channel) {
 
 	huma.Register(api, huma.Operation{
 		OperationID: "get-customers",
-		Summary:     "Get all customers",
+		Summary:     "List customers",
 		Method:      http.MethodGet,
 		Path:        "/customers",
 		Tags:        []string{"customers"},
-	}, func(ctx context.Context, input *struct{}) (*dto.CustomersOutput, error) {
-		return GetCustomers(ctx, dbConn)
+	}, func(ctx context.Context, input *dto.CustomersListInput) (*dto.CustomersOutput, error) {
+		if input.IncludeDeleted {
+			if err := requireAdmin(input.AdminToken); err != nil {
+				return nil, err
+			}
+		}
+		return service.GetCustomers(ctx, input)
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "search-customers",
+		Summary:     "Search customers",
+		Description: "Full-text, accent-insensitive search on name, username, company and city, ordered by relevance.",
+		Method:      http.MethodGet,
+		Path:        "/customers/search",
+		Tags:        []string{"customers"},
+	}, func(ctx context.Context, input *dto.CustomerSearchInput) (*dto.CustomerSearchOutput, error) {
+		return service.SearchCustomers(ctx, input)
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "get-username-availability",
+		Summary:     "Check whether a username is available",
+		Description: "Lets the sign-up form check a username before submitting. Usernames are case-insensitive: JDoe is taken if jdoe exists.",
+		Method:      http.MethodGet,
+		Path:        "/customers/username-availability",
+		Tags:        []string{"customers"},
+	}, func(ctx context.Context, input *dto.UsernameAvailabilityInput) (*dto.UsernameAvailabilityOutput, error) {
+		return service.CheckUsernameAvailability(ctx, input.Username)
 	})
 
 	huma.Register(api, huma.Operation{
@@ -217,9 +337,21 @@ func RegisterCustomerRoutes(api huma.API, dbConn *gorm.DB, ch *amqp.Channel) {
 		Path:        "/customers/{id}",
 		Tags:        []string{"customers"},
 	}, func(ctx context.Context, input *struct {
-		Id uint `path:"id"`
+		Id          uint     `path:"id"`
+		IfNoneMatch []string `header:"If-None-Match" doc:"Answer 304 Not Modified if the customer still has this ETag, ignored when orders are expanded"`
+		Expand      string   `query:"expand" enum:"orders" doc:"Set to orders to embed the customer orders, see also GET /customers/{id}/orders"`
 	}) (*dto.CustomerOutput, error) {
-		return GetCustomer(ctx, dbConn, input.Id)
+		return service.GetCustomer(ctx, input.Id, input.IfNoneMatch, input.Expand == "orders")
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "get-customer-orders",
+		Summary:     "List the orders of a customer",
+		Method:      http.MethodGet,
+		Path:        "/customers/{id}/orders",
+		Tags:        []string{"customers"},
+	}, func(ctx context.Context, input *dto.CustomerOrdersInput) (*dto.CustomerOrdersOutput, error) {
+		return service.GetCustomerOrders(ctx, input)
 	})
 
 	huma.Register(api, huma.Operation{
@@ -229,8 +361,16 @@ func RegisterCustomerRoutes(api huma.API, dbConn *gorm.DB, ch *amqp.Channel) {
 		DefaultStatus: http.StatusCreated,
 		Path:          "/customers",
 		Tags:          []string{"customers"},
+		Parameters: []*huma.Param{
+			{
+				Name:        "Idempotency-Key",
+				In:          "header",
+				Description: "Client generated key making retries safe: a retry with the same key and body replays the first response, the same key with another body is rejected with 422.",
+				Schema:      &huma.Schema{Type: huma.TypeString, MaxLength: &idempotencyKeyMaxLength},
+			},
+		},
 	}, func(ctx context.Context, input *dto.CustomerCreateInput) (*dto.CustomerOutput, error) {
-		return CreateCustomer(ctx, dbConn, ch, input)
+		return service.CreateCustomer(ctx, input)
 	})
 
 	huma.Register(api, huma.Operation{
@@ -240,23 +380,68 @@ func RegisterCustomerRoutes(api huma.API, dbConn *gorm.DB, ch *amqp.Channel) {
 		Path:        "/customers/{id}",
 		Tags:        []string{"customers"},
 	}, func(ctx context.Context, input *struct {
-		Id uint `path:"id"`
+		Id      uint     `path:"id"`
+		IfMatch []string `header:"If-Match" doc:"Only replace the customer if it still has this ETag"`
 		dto.CustomerCreateInput
 	}) (*dto.CustomerOutput, error) {
-		return UpdateCustomer(ctx, dbConn, ch, input.Id, input.CustomerCreateInput)
+		return service.UpdateCustomer(ctx, input.Id, input.IfMatch, input.CustomerCreateInput)
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID:  "patch-customer",
+		Summary:      "Partially update a customer",
+		Description:  "Accepts a JSON Merge Patch (application/merge-patch+json) or a JSON Patch (application/json-patch+json) applied to the same document as the PUT body.",
+		Method:       http.MethodPatch,
+		Path:         "/customers/{id}",
+		Tags:         []string{"customers"},
+		MaxBodyBytes: 64 * 1024,
+		RequestBody: &huma.RequestBody{
+			Content: map[string]*huma.MediaType{
+				jsonPatchContentType: {
+					Schema: &huma.Schema{
+						Type:  huma.TypeArray,
+						Items: &huma.Schema{Type: huma.TypeObject},
+					},
+				},
+			},
+		},
+	}, func(ctx context.Context, input *dto.CustomerPatchInput) (*dto.CustomerOutput, error) {
+		return service.PatchCustomer(ctx, input.Id, input.IfMatch, input.ContentType, input.RawBody)
 	})
 
 	huma.Register(api, huma.Operation{
 		OperationID:   "delete-customer",
 		Summary:       "Delete a customer",
+		Description:   "Soft-deletes the customer, it can be restored with POST /customers/{id}:restore. Admins can pass purge=true to delete it permanently.",
 		Method:        http.MethodDelete,
 		DefaultStatus: http.StatusNoContent,
 		Path:          "/customers/{id}",
 		Tags:          []string{"customers"},
 	}, func(ctx context.Context, input *struct {
-		Id uint `path:"id"`
+		Id         uint     `path:"id"`
+		IfMatch    []string `header:"If-Match" doc:"Only delete the customer if it still has this ETag"`
+		Purge      bool     `query:"purge" doc:"Permanently delete the customer instead of soft-deleting it (admin only)"`
+		AdminToken string   `header:"X-Admin-Token" doc:"Admin token, required to purge"`
 	}) (*struct{}, error) {
-		err := DeleteCustomer(ctx, dbConn, ch, input.Id)
+		if input.Purge {
+			if err := requireAdmin(input.AdminToken); err != nil {
+				return nil, err
+			}
+		}
+		err := service.DeleteCustomer(ctx, input.Id, input.IfMatch, input.Purge)
 		return &struct{}{}, err
 	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "restore-customer",
+		Summary:     "Restore a deleted customer",
+		Method:      http.MethodPost,
+		Path:        "/customers/{id}:restore",
+		Tags:        []string{"customers"},
+	}, func(ctx context.Context, input *struct {
+		Id      uint     `path:"id"`
+		IfMatch []string `header:"If-Match" doc:"Only restore the customer if it still has this ETag"`
+	}) (*dto.CustomerOutput, error) {
+		return service.RestoreCustomer(ctx, input.Id, input.IfMatch)
+	})
 }
@@ -1,241 +1,874 @@
-package operation_test
-
-import (
-	"context"
-	"errors"
-	"regexp"
-	"testing"
-
-	"github.com/DATA-DOG/go-sqlmock"
-	"github.com/PayeTonKawa-EPSI-2025/Common-V2/models"
-	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/dto"
-	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/operation"
-	"gorm.io/driver/postgres"
-	"gorm.io/gorm"
-)
-
-func setupMockDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
-	dbMock, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("failed to create sqlmock: %v", err)
-	}
-
-	gormDB, err := gorm.Open(postgres.New(postgres.Config{
-		Conn: dbMock,
-	}), &gorm.Config{})
-	if err != nil {
-		t.Fatalf("failed to open gorm DB: %v", err)
-	}
-
-	return gormDB, mock
-}
-
-func TestGetCustomers(t *testing.T) {
-	db, mock := setupMockDB(t)
-
-	rows := sqlmock.NewRows([]string{"id", "username", "first_name", "last_name"}).
-		AddRow(1, "jdoe", "John", "DOE").
-		AddRow(2, "asmith", "Alice", "SMITH")
-
-	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "customers"`)).WillReturnRows(rows)
-
-	resp, err := operation.GetCustomers(context.Background(), db)
-	if err != nil {
-		t.Fatalf("expected no error, got %v", err)
-	}
-
-	if len(resp.Body.Customers) != 2 {
-		t.Errorf("expected 2 customers, got %d", len(resp.Body.Customers))
-	}
-
-	if resp.Body.Customers[0].Username != "jdoe" {
-		t.Errorf("expected first customer 'jdoe', got '%s'", resp.Body.Customers[0].Username)
-	}
-
-	if err := mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("unfulfilled sqlmock expectations: %v", err)
-	}
-}
-
-func TestGetCustomersDBError(t *testing.T) {
-	db, mock := setupMockDB(t)
-
-	mock.ExpectQuery(`SELECT \* FROM "customers"`).
-		WillReturnError(errors.New("db failure"))
-
-	_, err := operation.GetCustomers(context.Background(), db)
-	if err == nil {
-		t.Fatal("expected error, got nil")
-	}
-}
-
-func TestGetCustomerOK(t *testing.T) {
-	db, mock := setupMockDB(t)
-
-	rows := sqlmock.NewRows([]string{"id", "username", "first_name", "last_name"}).
-		AddRow(1, "jdoe", "John", "DOE")
-
-	mock.ExpectQuery(regexp.QuoteMeta(
-		`SELECT * FROM "customers" WHERE "customers"."id" = $1 AND "customers"."deleted_at" IS NULL ORDER BY "customers"."id" LIMIT $2`,
-	)).
-		WithArgs(1, sqlmock.AnyArg()).
-		WillReturnRows(rows)
-
-	resp, err := operation.GetCustomer(context.Background(), db, 1)
-	if err != nil {
-		t.Fatalf("expected no error, got %v", err)
-	}
-
-	if resp.Body.Username != "jdoe" {
-		t.Errorf("expected username 'jdoe', got '%s'", resp.Body.Username)
-	}
-
-	if err := mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("unfulfilled sqlmock expectations: %v", err)
-	}
-}
-
-func TestGetCustomerNotFound(t *testing.T) {
-	db, mock := setupMockDB(t)
-
-	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "customers" WHERE "customers"."id" = $1`)).
-		WithArgs(1, sqlmock.AnyArg()).
-		WillReturnError(gorm.ErrRecordNotFound)
-
-	_, err := operation.GetCustomer(context.Background(), db, 1)
-	if err == nil {
-		t.Fatal("expected error for non-existent customer")
-	}
-}
-
-func TestGetCustomerDBError(t *testing.T) {
-	db, mock := setupMockDB(t)
-
-	mock.ExpectQuery(regexp.QuoteMeta(
-		`SELECT * FROM "customers" WHERE "customers"."id" = $1 AND "customers"."deleted_at" IS NULL ORDER BY "customers"."id" LIMIT $2`,
-	)).
-		WithArgs(1, sqlmock.AnyArg()).
-		WillReturnError(errors.New("db failure"))
-
-	_, err := operation.GetCustomer(context.Background(), db, 1)
-	if err == nil {
-		t.Fatal("expected error, got nil")
-	}
-
-	if err := mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("unfulfilled sqlmock expectations: %v", err)
-	}
-}
-
-func TestCreateCustomer(t *testing.T) {
-	db, mock := setupMockDB(t)
-
-	input := &dto.CustomerCreateInput{
-		Body: dto.CustomerCreateBody{
-			Username:  "jdoe",
-			FirstName: "john",
-			LastName:  "doe",
-			Address:   models.Address{}, // test address
-			Company:   models.Company{}, // test company
-		},
-	}
-
-	// Mock insert
-	mock.ExpectBegin()
-	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "customers"`)).
-		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
-			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
-		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
-	mock.ExpectCommit()
-
-	resp, err := operation.CreateCustomer(context.Background(), db, nil, input)
-	if err != nil {
-		t.Fatalf("expected no error, got %v", err)
-	}
-
-	if resp.Body.Username != "jdoe" {
-		t.Errorf("expected username 'jdoe', got '%s'", resp.Body.Username)
-	}
-
-	if resp.Body.FirstName != "John" {
-		t.Errorf("expected first name 'John', got '%s'", resp.Body.FirstName)
-	}
-
-	if resp.Body.LastName != "DOE" {
-		t.Errorf("expected last name 'DOE', got '%s'", resp.Body.LastName)
-	}
-
-	if err := mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("unfulfilled sqlmock expectations: %v", err)
-	}
-}
-
-func TestUpdateCustomer(t *testing.T) {
-	db, mock := setupMockDB(t)
-
-	// Mock selecting existing customer
-	mock.ExpectQuery(`SELECT \* FROM "customers".*`).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "first_name", "last_name"}).
-			AddRow(1, "jdoe", "John", "DOE"))
-
-	// Mock update
-	mock.ExpectBegin()
-	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "customers"`)).
-		WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectCommit()
-
-	input := dto.CustomerCreateInput{
-		Body: dto.CustomerCreateBody{
-			Username:  "jdoe2",
-			FirstName: "johnny",
-			LastName:  "doe",
-			Address:   models.Address{},
-			Company:   models.Company{},
-		},
-	}
-
-	resp, err := operation.UpdateCustomer(context.Background(), db, nil, 1, input)
-	if err != nil {
-		t.Fatalf("expected no error, got %v", err)
-	}
-
-	if resp.Body.Username != "jdoe2" {
-		t.Errorf("expected username 'jdoe2', got '%s'", resp.Body.Username)
-	}
-}
-
-func TestUpdateCustomerNotFound(t *testing.T) {
-	db, mock := setupMockDB(t)
-
-	mock.ExpectQuery(`SELECT \* FROM "customers"`).
-		WillReturnError(gorm.ErrRecordNotFound)
-
-	_, err := operation.UpdateCustomer(context.Background(), db, nil, 1, dto.CustomerCreateInput{})
-	if err == nil {
-		t.Fatal("expected not found error")
-	}
-}
-
-func TestDeleteCustomer(t *testing.T) {
-	db, mock := setupMockDB(t)
-
-	// Mock select existing customer
-	mock.ExpectQuery(regexp.QuoteMeta(
-		`SELECT * FROM "customers" WHERE "customers"."id" = $1 AND "customers"."deleted_at" IS NULL ORDER BY "customers"."id" LIMIT $2`,
-	)).
-		WithArgs(1, sqlmock.AnyArg()). // first arg is id, second is limit
-		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "first_name", "last_name"}).AddRow(1, "jdoe", "John", "DOE"))
-
-	// Mock soft delete
-	mock.ExpectBegin()
-	mock.ExpectExec(regexp.QuoteMeta(
-		`UPDATE "customers" SET "deleted_at"=$1 WHERE "customers"."id" = $2 AND "customers"."deleted_at" IS NULL`)).
-		WithArgs(sqlmock.AnyArg(), 1).
-		WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectCommit()
-
-	err := operation.DeleteCustomer(context.Background(), db, nil, 1)
-	if err != nil {
-		t.Fatalf("expected no error, got %v", err)
-	}
-}
+package operation_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PayeTonKawa-EPSI-2025/Common-V2/events"
+	"github.com/PayeTonKawa-EPSI-2025/Common-V2/models"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/dto"
+	localModels "github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/models"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/operation"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/rabbitmq"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/repository"
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/humatest"
+	"gorm.io/gorm"
+)
+
+// testNow is the time of the clock given to the service
+var testNow = time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// recordingPublisher records the events published by the operations
+type recordingPublisher struct {
+	events []rabbitmq.CustomerChangeEvent
+}
+
+func (p *recordingPublisher) PublishCustomerEvent(ctx context.Context, event rabbitmq.CustomerChangeEvent) error {
+	p.events = append(p.events, event)
+	return nil
+}
+
+// ordersFunc adapts a function to operation.OrdersClient
+type ordersFunc func(ctx context.Context, customerID uint) ([]models.Order, error)
+
+func (f ordersFunc) CustomerOrders(ctx context.Context, customerID uint) ([]models.Order, error) {
+	return f(ctx, customerID)
+}
+
+// ordersUnavailable fails like an unreachable Orders service
+var ordersUnavailable = ordersFunc(func(ctx context.Context, customerID uint) ([]models.Order, error) {
+	return nil, errors.New("orders service unavailable")
+})
+
+// newService returns a service on repo with a fixed clock and sequential event IDs,
+// along with the publisher recording its events
+func newService(repo repository.CustomerRepository, orders operation.OrdersClient) (*operation.CustomerService, *recordingPublisher) {
+	publisher := &recordingPublisher{}
+	ids := 0
+	newID := func() string {
+		ids++
+		return fmt.Sprintf("event-%d", ids)
+	}
+	clock := func() time.Time { return testNow }
+	return operation.NewCustomerService(repo, publisher, orders, clock, newID), publisher
+}
+
+// newCustomer returns a stored customer
+func newCustomer(id uint, username, firstname, lastname string) localModels.Customer {
+	c := localModels.Customer{
+		Customer: models.Customer{
+			Username:  username,
+			FirstName: firstname,
+			LastName:  lastname,
+			Name:      firstname + " " + lastname,
+		},
+		Version: 1,
+	}
+	c.ID = id
+	c.CreatedAt = time.Date(2025, 1, int(id), 0, 0, 0, 0, time.UTC)
+	return c
+}
+
+// expectEvents checks the events published by the operations
+func expectEvents(t *testing.T, publisher *recordingPublisher, types ...events.EventType) {
+	t.Helper()
+	if len(publisher.events) != len(types) {
+		t.Fatalf("expected %d events, got %+v", len(types), publisher.events)
+	}
+	for i, event := range publisher.events {
+		if event.Type != types[i] {
+			t.Errorf("expected event %d to be %s, got %s", i, types[i], event.Type)
+		}
+	}
+}
+
+// expectStatus checks that err is an HTTP error with the given status
+func expectStatus(t *testing.T, err error, status int) {
+	t.Helper()
+	var statusErr huma.StatusError
+	if !errors.As(err, &statusErr) || statusErr.GetStatus() != status {
+		t.Fatalf("expected %d error, got %v", status, err)
+	}
+}
+
+func TestGetCustomers(t *testing.T) {
+	repo := repository.NewMemoryCustomerRepository(
+		newCustomer(1, "jdoe", "John", "DOE"),
+		newCustomer(2, "asmith", "Alice", "SMITH"),
+	)
+	service, _ := newService(repo, ordersUnavailable)
+
+	resp, err := service.GetCustomers(context.Background(), &dto.CustomersListInput{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(resp.Body.Customers) != 2 {
+		t.Errorf("expected 2 customers, got %d", len(resp.Body.Customers))
+	}
+
+	if resp.Body.Customers[0].Username != "jdoe" {
+		t.Errorf("expected first customer 'jdoe', got '%s'", resp.Body.Customers[0].Username)
+	}
+
+	if resp.Body.NextCursor != "" || resp.Link != "" {
+		t.Errorf("expected no next page, got cursor %q and link %q", resp.Body.NextCursor, resp.Link)
+	}
+}
+
+func TestGetCustomersPagination(t *testing.T) {
+	paris := func(c localModels.Customer) localModels.Customer {
+		c.Address.City = "Paris"
+		return c
+	}
+	repo := repository.NewMemoryCustomerRepository(
+		paris(newCustomer(1, "jdoe", "John", "DOE")),
+		paris(newCustomer(2, "asmith", "Alice", "SMITH")),
+		newCustomer(3, "zlyon", "Zoe", "LYON"),
+	)
+	service, _ := newService(repo, ordersUnavailable)
+
+	input := &dto.CustomersListInput{Limit: 1, Sort: "-name", City: "Paris"}
+	resp, err := service.GetCustomers(context.Background(), input)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(resp.Body.Customers) != 1 || resp.Body.Customers[0].Username != "jdoe" {
+		t.Fatalf("expected jdoe only, got %+v", resp.Body.Customers)
+	}
+
+	if resp.Body.NextCursor == "" {
+		t.Fatal("expected a next cursor")
+	}
+
+	if !strings.Contains(resp.Link, `rel="next"`) || !strings.Contains(resp.Link, "city=Paris") {
+		t.Errorf("unexpected Link header %q", resp.Link)
+	}
+
+	// Second page continues after the last customer of the first one
+	input = &dto.CustomersListInput{Limit: 1, Sort: "-name", City: "Paris", Cursor: resp.Body.NextCursor}
+	resp, err = service.GetCustomers(context.Background(), input)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(resp.Body.Customers) != 1 || resp.Body.Customers[0].Username != "asmith" {
+		t.Fatalf("expected asmith only, got %+v", resp.Body.Customers)
+	}
+
+	if resp.Body.NextCursor != "" {
+		t.Errorf("expected last page, got cursor %q", resp.Body.NextCursor)
+	}
+}
+
+func TestGetCustomersExcludesDeleted(t *testing.T) {
+	deleted := newCustomer(2, "asmith", "Alice", "SMITH")
+	deleted.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	repo := repository.NewMemoryCustomerRepository(newCustomer(1, "jdoe", "John", "DOE"), deleted)
+	service, _ := newService(repo, ordersUnavailable)
+
+	resp, err := service.GetCustomers(context.Background(), &dto.CustomersListInput{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(resp.Body.Customers) != 1 {
+		t.Errorf("expected 1 customer, got %d", len(resp.Body.Customers))
+	}
+
+	resp, err = service.GetCustomers(context.Background(), &dto.CustomersListInput{IncludeDeleted: true})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(resp.Body.Customers) != 2 {
+		t.Errorf("expected 2 customers, got %d", len(resp.Body.Customers))
+	}
+}
+
+func TestGetCustomersIncludeDeletedRequiresAdmin(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	service, _ := newService(repository.NewMemoryCustomerRepository(), ordersUnavailable)
+	_, api := humatest.New(t)
+	operation.RegisterCustomerRoutes(api, service)
+
+	if resp := api.Get("/customers?include_deleted=true"); resp.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without admin token, got %d", resp.Code)
+	}
+	if resp := api.Get("/customers?include_deleted=true", operation.AdminTokenHeader+": wrong"); resp.Code != http.StatusForbidden {
+		t.Errorf("expected 403 with a wrong admin token, got %d", resp.Code)
+	}
+	if resp := api.Get("/customers?include_deleted=true", operation.AdminTokenHeader+": secret"); resp.Code != http.StatusOK {
+		t.Errorf("expected 200 with the admin token, got %d", resp.Code)
+	}
+	if resp := api.Get("/customers"); resp.Code != http.StatusOK {
+		t.Errorf("expected 200 without include_deleted, got %d", resp.Code)
+	}
+}
+
+func TestGetCustomersInvalidCursor(t *testing.T) {
+	repo := repository.NewMemoryCustomerRepository()
+	service, _ := newService(repo, ordersUnavailable)
+
+	_, err := service.GetCustomers(context.Background(), &dto.CustomersListInput{Cursor: "not-a-cursor"})
+	expectStatus(t, err, http.StatusBadRequest)
+}
+
+func TestGetCustomerOK(t *testing.T) {
+	repo := repository.NewMemoryCustomerRepository(newCustomer(1, "jdoe", "John", "DOE"))
+	service, _ := newService(repo, ordersUnavailable)
+
+	resp, err := service.GetCustomer(context.Background(), 1, nil, false)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if resp.Body.Username != "jdoe" {
+		t.Errorf("expected username 'jdoe', got '%s'", resp.Body.Username)
+	}
+
+	if resp.ETag != `"1"` {
+		t.Errorf(`expected ETag "1", got %s`, resp.ETag)
+	}
+}
+
+func TestGetCustomerNotFound(t *testing.T) {
+	repo := repository.NewMemoryCustomerRepository()
+	service, _ := newService(repo, ordersUnavailable)
+
+	_, err := service.GetCustomer(context.Background(), 1, nil, false)
+	expectStatus(t, err, http.StatusNotFound)
+}
+
+func TestGetCustomerNotModified(t *testing.T) {
+	customer := newCustomer(1, "jdoe", "John", "DOE")
+	customer.Version = 3
+	repo := repository.NewMemoryCustomerRepository(customer)
+	service, _ := newService(repo, ordersUnavailable)
+
+	_, err := service.GetCustomer(context.Background(), 1, []string{`W/"3"`}, false)
+	expectStatus(t, err, http.StatusNotModified)
+
+	var headersErr huma.HeadersError
+	if !errors.As(err, &headersErr) || headersErr.GetHeaders().Get("ETag") != `"3"` {
+		t.Errorf(`expected the 304 to carry ETag "3", got %v`, err)
+	}
+}
+
+func TestGetCustomerExpandedIgnoresIfNoneMatch(t *testing.T) {
+	customer := newCustomer(1, "jdoe", "John", "DOE")
+	customer.Version = 3
+	repo := repository.NewMemoryCustomerRepository(customer)
+	repo.AddOrders(1, newOrder(10, "paid", time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)))
+	service, _ := newService(repo, ordersUnavailable)
+
+	// An order was added since the customer was last fetched, its version did not change
+	resp, err := service.GetCustomer(context.Background(), 1, []string{`"3"`}, true)
+	if err != nil {
+		t.Fatalf("expected the expanded customer to be sent again, got %v", err)
+	}
+	if len(resp.Body.Orders) != 1 || resp.ETag != `"3"` {
+		t.Errorf("expected order 10 and the customer ETag, got %+v", resp)
+	}
+}
+
+func TestCreateCustomer(t *testing.T) {
+	repo := repository.NewMemoryCustomerRepository()
+	service, published := newService(repo, ordersUnavailable)
+
+	input := &dto.CustomerCreateInput{
+		Body: dto.CustomerCreateBody{
+			Username:  "jdoe",
+			FirstName: "john",
+			LastName:  "doe",
+			Address:   models.Address{}, // test address
+			Company:   models.Company{}, // test company
+		},
+	}
+
+	resp, err := service.CreateCustomer(context.Background(), input)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if resp.Body.Username != "jdoe" {
+		t.Errorf("expected username 'jdoe', got '%s'", resp.Body.Username)
+	}
+
+	if resp.Body.FirstName != "John" {
+		t.Errorf("expected first name 'John', got '%s'", resp.Body.FirstName)
+	}
+
+	if resp.Body.LastName != "DOE" {
+		t.Errorf("expected last name 'DOE', got '%s'", resp.Body.LastName)
+	}
+
+	if _, err := repo.Get(context.Background(), resp.Body.ID, false); err != nil {
+		t.Errorf("expected customer to be stored, got %v", err)
+	}
+	expectEvents(t, published, events.CustomerCreated)
+	if event := published.events[0]; event.ID != "event-1" || !event.Timestamp.Equal(testNow) {
+		t.Errorf("expected the event to be stamped by the service, got ID %q at %s", event.ID, event.Timestamp)
+	}
+}
+
+func TestCreateCustomerNormalizesInput(t *testing.T) {
+	repo := repository.NewMemoryCustomerRepository()
+	service, _ := newService(repo, ordersUnavailable)
+
+	input := &dto.CustomerCreateInput{
+		Body: dto.CustomerCreateBody{
+			Username:  "jdoe",
+			FirstName: "  élodie   marie ",
+			LastName:  "lefèvre\t",
+			Address:   models.Address{PostalCode: "75 001", City: " Paris "},
+		},
+	}
+
+	resp, err := service.CreateCustomer(context.Background(), input)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	c := resp.Body.Customer
+	if c.Username != "jdoe" || c.Name != "Élodie Marie LEFÈVRE" || c.Address.PostalCode != "75001" || c.Address.City != "Paris" {
+		t.Errorf("expected normalised customer, got %+v", c)
+	}
+}
+
+func TestCreateCustomerUnsupportedCountry(t *testing.T) {
+	t.Setenv("CUSTOMER_COUNTRY", "XX")
+	if err := dto.CheckCustomerCountry(); err == nil {
+		t.Error("expected an unsupported country to be refused")
+	}
+
+	service, _ := newService(repository.NewMemoryCustomerRepository(), ordersUnavailable)
+	_, api := humatest.New(t)
+	operation.RegisterCustomerRoutes(api, service)
+
+	resp := api.Post("/customers", map[string]any{
+		"username":  "jdoe",
+		"firstname": "john",
+		"lastname":  "doe",
+		"address":   map[string]any{"postalCode": "75001", "city": "Paris"},
+	})
+	if resp.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected postal codes to be rejected, got %d: %s", resp.Code, resp.Body.String())
+	}
+}
+
+func TestCreateCustomerUsernameTaken(t *testing.T) {
+	repo := repository.NewMemoryCustomerRepository(newCustomer(1, "jdoe", "John", "DOE"))
+	service, published := newService(repo, ordersUnavailable)
+
+	input := &dto.CustomerCreateInput{
+		Body: dto.CustomerCreateBody{Username: "JDoe", FirstName: "john", LastName: "doe"},
+	}
+
+	_, err := service.CreateCustomer(context.Background(), input)
+
+	var model *huma.ErrorModel
+	if !errors.As(err, &model) || model.Status != http.StatusConflict {
+		t.Fatalf("expected 409 error, got %v", err)
+	}
+	if len(model.Errors) != 1 || model.Errors[0].Location != "body.username" || model.Errors[0].Value != "JDoe" {
+		t.Errorf("expected the conflict to name the username, got %+v", model.Errors)
+	}
+	expectEvents(t, published)
+}
+
+func TestCreateCustomerInvalidBody(t *testing.T) {
+	service, _ := newService(repository.NewMemoryCustomerRepository(), ordersUnavailable)
+	_, api := humatest.New(t)
+	operation.RegisterCustomerRoutes(api, service)
+
+	resp := api.Post("/customers", map[string]any{
+		"username":  "j d",
+		"firstname": "   ",
+		"lastname":  strings.Repeat("x", 101),
+		"address":   map[string]any{"postalCode": "ABC", "city": "Paris"},
+	})
+
+	if resp.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d: %s", resp.Code, resp.Body.String())
+	}
+	if contentType := resp.Header().Get("Content-Type"); contentType != "application/problem+json" {
+		t.Errorf("expected problem details, got %s", contentType)
+	}
+
+	var problem huma.ErrorModel
+	if err := json.Unmarshal(resp.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("invalid problem details: %v", err)
+	}
+	locations := map[string]bool{}
+	for _, detail := range problem.Errors {
+		locations[detail.Location] = true
+	}
+	for _, location := range []string{"body.username", "body.firstname", "body.lastname", "body.address.postalCode"} {
+		if !locations[location] {
+			t.Errorf("expected an error for %s, got %+v", location, problem.Errors)
+		}
+	}
+}
+
+func TestUpdateCustomer(t *testing.T) {
+	repo := repository.NewMemoryCustomerRepository(newCustomer(1, "jdoe", "John", "DOE"))
+	service, published := newService(repo, ordersUnavailable)
+
+	input := dto.CustomerCreateInput{
+		Body: dto.CustomerCreateBody{
+			Username:  "jdoe2",
+			FirstName: "johnny",
+			LastName:  "doe",
+			Address:   models.Address{},
+			Company:   models.Company{},
+		},
+	}
+
+	resp, err := service.UpdateCustomer(context.Background(), 1, nil, input)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if resp.Body.Username != "jdoe2" || resp.Body.Name != "Johnny DOE" {
+		t.Errorf("expected username 'jdoe2' and name 'Johnny DOE', got '%s' and '%s'", resp.Body.Username, resp.Body.Name)
+	}
+
+	if resp.ETag != `"2"` {
+		t.Errorf(`expected ETag "2", got %s`, resp.ETag)
+	}
+	expectEvents(t, published, events.CustomerUpdated)
+}
+
+func TestUpdateCustomerNotFound(t *testing.T) {
+	repo := repository.NewMemoryCustomerRepository()
+	service, _ := newService(repo, ordersUnavailable)
+
+	_, err := service.UpdateCustomer(context.Background(), 1, nil, dto.CustomerCreateInput{})
+	expectStatus(t, err, http.StatusNotFound)
+}
+
+func TestUpdateCustomerPreconditionFailed(t *testing.T) {
+	customer := newCustomer(1, "jdoe", "John", "DOE")
+	customer.Version = 3
+	repo := repository.NewMemoryCustomerRepository(customer)
+	service, published := newService(repo, ordersUnavailable)
+
+	_, err := service.UpdateCustomer(context.Background(), 1, []string{`"2"`}, dto.CustomerCreateInput{})
+	expectStatus(t, err, http.StatusPreconditionFailed)
+	expectEvents(t, published)
+}
+
+// staleRepository simulates another writer bumping the version between a read and a write
+type staleRepository struct {
+	*repository.MemoryCustomerRepository
+}
+
+func (r staleRepository) Update(ctx context.Context, customer *localModels.Customer, columns ...string) error {
+	return repository.ErrStale
+}
+
+func TestUpdateCustomerConcurrentWrite(t *testing.T) {
+	customer := newCustomer(1, "jdoe", "John", "DOE")
+	customer.Version = 3
+	repo := staleRepository{repository.NewMemoryCustomerRepository(customer)}
+	service, _ := newService(repo, ordersUnavailable)
+
+	_, err := service.UpdateCustomer(context.Background(), 1, []string{`"3"`}, dto.CustomerCreateInput{})
+	expectStatus(t, err, http.StatusPreconditionFailed)
+
+	// Without If-Match the client is asked to retry
+	_, err = service.UpdateCustomer(context.Background(), 1, nil, dto.CustomerCreateInput{})
+	expectStatus(t, err, http.StatusConflict)
+}
+
+func TestDeleteCustomer(t *testing.T) {
+	repo := repository.NewMemoryCustomerRepository(newCustomer(1, "jdoe", "John", "DOE"))
+	service, published := newService(repo, ordersUnavailable)
+
+	err := service.DeleteCustomer(context.Background(), 1, nil, false)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// Soft-deleted customers are hidden but kept
+	if _, err := repo.Get(context.Background(), 1, false); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected customer to be hidden, got %v", err)
+	}
+	if _, err := repo.Get(context.Background(), 1, true); err != nil {
+		t.Errorf("expected customer to be kept, got %v", err)
+	}
+	expectEvents(t, published, events.CustomerDeleted)
+}
+
+func TestDeleteCustomerStalesETag(t *testing.T) {
+	repo := repository.NewMemoryCustomerRepository(newCustomer(1, "jdoe", "John", "DOE"))
+	service, _ := newService(repo, ordersUnavailable)
+
+	if err := service.DeleteCustomer(context.Background(), 1, []string{`"1"`}, false); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	resp, err := service.RestoreCustomer(context.Background(), 1, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// Both the delete and the restore changed the customer
+	if resp.ETag != `"3"` {
+		t.Errorf(`expected ETag "3", got %s`, resp.ETag)
+	}
+	_, err = service.UpdateCustomer(context.Background(), 1, []string{`"1"`}, dto.CustomerCreateInput{})
+	expectStatus(t, err, http.StatusPreconditionFailed)
+}
+
+func TestDeleteCustomerPurge(t *testing.T) {
+	// Purge also finds soft-deleted customers, which were already announced as deleted
+	customer := newCustomer(1, "jdoe", "John", "DOE")
+	customer.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	repo := repository.NewMemoryCustomerRepository(customer)
+	service, published := newService(repo, ordersUnavailable)
+
+	err := service.DeleteCustomer(context.Background(), 1, nil, true)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if _, err := repo.Get(context.Background(), 1, true); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected customer to be purged, got %v", err)
+	}
+	expectEvents(t, published)
+}
+
+func TestRestoreCustomer(t *testing.T) {
+	customer := newCustomer(1, "jdoe", "John", "DOE")
+	customer.Version = 2
+	customer.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	repo := repository.NewMemoryCustomerRepository(customer)
+	service, published := newService(repo, ordersUnavailable)
+
+	resp, err := service.RestoreCustomer(context.Background(), 1, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if resp.ETag != `"3"` {
+		t.Errorf(`expected ETag "3", got %s`, resp.ETag)
+	}
+	if _, err := repo.Get(context.Background(), 1, false); err != nil {
+		t.Errorf("expected customer to be restored, got %v", err)
+	}
+	expectEvents(t, published, events.CustomerUpdated)
+}
+
+func TestRestoreCustomerIfMatch(t *testing.T) {
+	customer := newCustomer(1, "jdoe", "John", "DOE")
+	customer.Version = 2
+	customer.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	repo := repository.NewMemoryCustomerRepository(customer)
+	service, published := newService(repo, ordersUnavailable)
+
+	_, err := service.RestoreCustomer(context.Background(), 1, []string{`"1"`})
+	expectStatus(t, err, http.StatusPreconditionFailed)
+	expectEvents(t, published)
+
+	resp, err := service.RestoreCustomer(context.Background(), 1, []string{`"2"`})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if resp.ETag != `"3"` {
+		t.Errorf(`expected ETag "3", got %s`, resp.ETag)
+	}
+}
+
+func TestRestoreCustomerNotDeleted(t *testing.T) {
+	repo := repository.NewMemoryCustomerRepository(newCustomer(1, "jdoe", "John", "DOE"))
+	service, _ := newService(repo, ordersUnavailable)
+
+	_, err := service.RestoreCustomer(context.Background(), 1, nil)
+	expectStatus(t, err, http.StatusConflict)
+}
+
+func TestRestoreCustomerUsernameTaken(t *testing.T) {
+	deleted := newCustomer(1, "jdoe", "John", "DOE")
+	deleted.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	repo := repository.NewMemoryCustomerRepository(deleted, newCustomer(2, "JDOE", "Jane", "DOE"))
+	service, published := newService(repo, ordersUnavailable)
+
+	_, err := service.RestoreCustomer(context.Background(), 1, nil)
+	expectStatus(t, err, http.StatusConflict)
+	expectEvents(t, published)
+}
+
+func TestSearchCustomers(t *testing.T) {
+	hdupont := newCustomer(1, "hdupont", "Hélène", "DUPONT")
+	hdupont.Address.City = "Lyon"
+	repo := repository.NewMemoryCustomerRepository(hdupont, newCustomer(2, "jdoe", "John", "DOE"))
+	service, _ := newService(repo, ordersUnavailable)
+
+	resp, err := service.SearchCustomers(context.Background(), &dto.CustomerSearchInput{Q: "helene, dup!"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(resp.Body.Results) != 1 {
+		t.Fatalf("expected 1 result, got %d", len(resp.Body.Results))
+	}
+
+	if resp.Body.Results[0].Customer.Username != "hdupont" {
+		t.Errorf("expected username 'hdupont', got '%s'", resp.Body.Results[0].Customer.Username)
+	}
+
+	if !strings.Contains(resp.Body.Results[0].Snippet, "<mark>Hélène</mark>") {
+		t.Errorf("expected a highlighted snippet, got %q", resp.Body.Results[0].Snippet)
+	}
+}
+
+func TestSearchCustomersEmptyQuery(t *testing.T) {
+	repo := repository.NewMemoryCustomerRepository()
+	service, _ := newService(repo, ordersUnavailable)
+
+	_, err := service.SearchCustomers(context.Background(), &dto.CustomerSearchInput{Q: "&|!"})
+	expectStatus(t, err, http.StatusBadRequest)
+}
+
+func TestPatchCustomerMergePatch(t *testing.T) {
+	customer := newCustomer(1, "jdoe", "John", "DOE")
+	customer.Address.City = "Paris"
+	repo := repository.NewMemoryCustomerRepository(customer)
+	service, published := newService(repo, ordersUnavailable)
+
+	patch := []byte(`{"address": {"city": "Lyon"}}`)
+	resp, err := service.PatchCustomer(context.Background(), 1, nil, "application/merge-patch+json", patch)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if resp.Body.Address.City != "Lyon" {
+		t.Errorf("expected city 'Lyon', got '%s'", resp.Body.Address.City)
+	}
+
+	expectEvents(t, published, events.CustomerUpdated)
+	if changed := published.events[0].ChangedFields; len(changed) != 1 || changed[0] != "address.city" {
+		t.Errorf("expected only address.city to change, got %v", changed)
+	}
+}
+
+func TestPatchCustomerJSONPatchNormalizesName(t *testing.T) {
+	repo := repository.NewMemoryCustomerRepository(newCustomer(1, "jdoe", "John", "DOE"))
+	service, _ := newService(repo, ordersUnavailable)
+
+	patch := []byte(`[{"op": "replace", "path": "/firstname", "value": "johnny"}]`)
+	resp, err := service.PatchCustomer(context.Background(), 1, nil, "application/json-patch+json", patch)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if resp.Body.FirstName != "Johnny" || resp.Body.Profile.FirstName != "Johnny" || resp.Body.Name != "Johnny DOE" {
+		t.Errorf("expected first name 'Johnny' everywhere, got %+v", resp.Body.Customer)
+	}
+}
+
+func TestPatchCustomerNoChange(t *testing.T) {
+	repo := repository.NewMemoryCustomerRepository(newCustomer(1, "jdoe", "John", "DOE"))
+	service, published := newService(repo, ordersUnavailable)
+
+	// Names are compared once normalised
+	patch := []byte(`{"firstname": "john"}`)
+	resp, err := service.PatchCustomer(context.Background(), 1, nil, "application/merge-patch+json", patch)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if resp.ETag != `"1"` {
+		t.Errorf(`expected ETag "1", got %s`, resp.ETag)
+	}
+	expectEvents(t, published)
+}
+
+func TestPatchCustomerUnsupportedContentType(t *testing.T) {
+	repo := repository.NewMemoryCustomerRepository(newCustomer(1, "jdoe", "John", "DOE"))
+	service, _ := newService(repo, ordersUnavailable)
+
+	_, err := service.PatchCustomer(context.Background(), 1, nil, "text/plain", []byte(`{}`))
+	expectStatus(t, err, http.StatusUnsupportedMediaType)
+}
+
+func TestPatchCustomerInvalidFields(t *testing.T) {
+	// The stored postal code predates validation and is left alone
+	customer := newCustomer(1, "jdoe", "John", "DOE")
+	customer.Address.PostalCode = "unknown"
+	repo := repository.NewMemoryCustomerRepository(customer)
+	service, published := newService(repo, ordersUnavailable)
+
+	patch := []byte(`{"username": "", "lastname": "  "}`)
+	_, err := service.PatchCustomer(context.Background(), 1, nil, "application/merge-patch+json", patch)
+
+	var model *huma.ErrorModel
+	if !errors.As(err, &model) || model.Status != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 error, got %v", err)
+	}
+	if len(model.Errors) != 2 {
+		t.Errorf("expected errors on username and lastname only, got %+v", model.Errors)
+	}
+	expectEvents(t, published)
+}
+
+func TestCheckUsernameAvailability(t *testing.T) {
+	repo := repository.NewMemoryCustomerRepository(newCustomer(1, "jdoe", "John", "DOE"))
+	service, _ := newService(repo, ordersUnavailable)
+
+	resp, err := service.CheckUsernameAvailability(context.Background(), "JDoe")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if resp.Body.Available {
+		t.Error("expected username to be taken")
+	}
+
+	resp, err = service.CheckUsernameAvailability(context.Background(), "asmith")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !resp.Body.Available {
+		t.Error("expected username to be available")
+	}
+}
+
+// newOrder returns a projected order
+func newOrder(id uint, status string, orderedAt time.Time, productIDs ...uint) localModels.Order {
+	order := localModels.Order{Status: status, OrderedAt: orderedAt, ProductIDs: productIDs}
+	order.ID = id
+	return order
+}
+
+func TestGetCustomerOrdersFromProjection(t *testing.T) {
+	t.Setenv("ORDERS_FROM_SERVICE", "false")
+	repo := repository.NewMemoryCustomerRepository(newCustomer(1, "jdoe", "John", "DOE"))
+	order := newOrder(10, "paid", time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), 3, 4)
+	order.Total = 42.5
+	repo.AddOrders(1, order)
+	service, _ := newService(repo, ordersUnavailable)
+
+	resp, err := service.GetCustomer(context.Background(), 1, nil, true)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(resp.Body.Orders) != 1 || resp.Body.Orders[0].ID != 10 || len(resp.Body.Orders[0].Products) != 2 {
+		t.Errorf("expected order 10 with 2 products, got %+v", resp.Body.Orders)
+	}
+	if got := resp.Body.Orders[0]; got.Status != "paid" || got.Total != 42.5 || got.CustomerID != 1 {
+		t.Errorf("expected every projected field to be mapped, got %+v", got)
+	}
+	if resp.Body.OrdersDegraded {
+		t.Error("expected orders not to be degraded")
+	}
+}
+
+func TestGetCustomerOrdersFromOrdersService(t *testing.T) {
+	t.Setenv("ORDERS_FROM_SERVICE", "true")
+	repo := repository.NewMemoryCustomerRepository(newCustomer(1, "jdoe", "John", "DOE"))
+	service, _ := newService(repo, ordersFunc(func(ctx context.Context, customerID uint) ([]models.Order, error) {
+		order := models.Order{CustomerID: customerID}
+		order.ID = 20
+		return []models.Order{order}, nil
+	}))
+
+	resp, err := service.GetCustomer(context.Background(), 1, nil, true)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(resp.Body.Orders) != 1 || resp.Body.Orders[0].ID != 20 || resp.Body.OrdersDegraded {
+		t.Errorf("expected order 20 from the Orders service, got %+v", resp.Body)
+	}
+}
+
+func TestGetCustomerOrdersDegraded(t *testing.T) {
+	t.Setenv("ORDERS_FROM_SERVICE", "true")
+	repo := repository.NewMemoryCustomerRepository(newCustomer(1, "jdoe", "John", "DOE"))
+	repo.AddOrders(1, newOrder(10, "paid", time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)))
+	service, _ := newService(repo, ordersUnavailable)
+
+	// The partial projection is used while the Orders service is unavailable
+	resp, err := service.GetCustomer(context.Background(), 1, nil, true)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(resp.Body.Orders) != 1 || resp.Body.Orders[0].ID != 10 || !resp.Body.OrdersDegraded {
+		t.Errorf("expected degraded order 10 from the projection, got %+v", resp.Body)
+	}
+}
+
+func TestGetCustomerOrdersPagination(t *testing.T) {
+	t.Setenv("ORDERS_FROM_SERVICE", "false")
+	repo := repository.NewMemoryCustomerRepository(newCustomer(7, "jdoe", "John", "DOE"))
+	repo.AddOrders(7,
+		newOrder(11, "shipped", time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)),
+		newOrder(12, "paid", time.Date(2025, 3, 2, 0, 0, 0, 0, time.UTC)),
+		newOrder(13, "paid", time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC)),
+	)
+	service, _ := newService(repo, ordersUnavailable)
+
+	input := &dto.CustomerOrdersInput{
+		Id:           7,
+		Limit:        1,
+		OrderedAfter: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	resp, err := service.GetCustomerOrders(context.Background(), input)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(resp.Body.Orders) != 1 || resp.Body.Orders[0].ID != 12 || resp.Body.Orders[0].Status != "paid" {
+		t.Errorf("expected order 12 only, got %+v", resp.Body.Orders)
+	}
+	if resp.Body.NextCursor == "" || !strings.Contains(resp.Link, "/customers/7/orders?") {
+		t.Fatalf("expected a next page, got cursor %q and link %q", resp.Body.NextCursor, resp.Link)
+	}
+
+	// The second page continues after order 12 and skips the order before the filter
+	input.Cursor = resp.Body.NextCursor
+	resp, err = service.GetCustomerOrders(context.Background(), input)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(resp.Body.Orders) != 1 || resp.Body.Orders[0].ID != 11 || resp.Body.NextCursor != "" {
+		t.Errorf("expected order 11 on the last page, got %+v", resp.Body)
+	}
+}
+
+func TestGetCustomerOrdersDefaultLimit(t *testing.T) {
+	t.Setenv("ORDERS_FROM_SERVICE", "false")
+	repo := repository.NewMemoryCustomerRepository(newCustomer(7, "jdoe", "John", "DOE"))
+	repo.AddOrders(7,
+		newOrder(11, "shipped", time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)),
+		newOrder(12, "paid", time.Date(2025, 3, 2, 0, 0, 0, 0, time.UTC)),
+	)
+	service, _ := newService(repo, ordersUnavailable)
+
+	resp, err := service.GetCustomerOrders(context.Background(), &dto.CustomerOrdersInput{Id: 7, Limit: 0})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if resp.Body.Limit != 20 || len(resp.Body.Orders) != 2 || resp.Body.NextCursor != "" {
+		t.Errorf("expected both orders with the default limit, got %+v", resp.Body)
+	}
+}
+
+func TestGetCustomerOrdersCustomerNotFound(t *testing.T) {
+	repo := repository.NewMemoryCustomerRepository()
+	service, _ := newService(repo, ordersUnavailable)
+
+	_, err := service.GetCustomerOrders(context.Background(), &dto.CustomerOrdersInput{Id: 7, Limit: 20})
+	expectStatus(t, err, http.StatusNotFound)
+}
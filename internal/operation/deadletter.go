@@ -0,0 +1,91 @@
+package operation
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/dto"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/rabbitmq"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// deadLetterError maps dead-letter queue errors to HTTP errors
+func deadLetterError(err error) error {
+	switch {
+	case errors.Is(err, rabbitmq.ErrDeadLetterNotFound):
+		return huma.NewError(http.StatusNotFound, "Dead letter not found")
+	case errors.Is(err, rabbitmq.ErrNotConnected):
+		return huma.NewError(http.StatusServiceUnavailable, "RabbitMQ is unavailable")
+	default:
+		return err
+	}
+}
+
+// DeadLetterQueue browses and re-drives dead-lettered events, see rabbitmq.DeadLetters
+type DeadLetterQueue interface {
+	List(limit int) ([]rabbitmq.DeadLetter, int, error)
+	Get(id string) (*rabbitmq.DeadLetter, error)
+	Redrive(ctx context.Context, id string) (*rabbitmq.DeadLetter, error)
+}
+
+// RegisterDeadLetterRoutes registers the admin routes managing the dead-letter queue
+func RegisterDeadLetterRoutes(api huma.API, deadLetters DeadLetterQueue) {
+	huma.Register(api, huma.Operation{
+		OperationID: "get-dead-letters",
+		Summary:     "List dead-lettered events",
+		Method:      http.MethodGet,
+		Path:        "/admin/dead-letters",
+		Tags:        []string{"admin"},
+	}, func(ctx context.Context, input *struct {
+		Limit      int    `query:"limit" minimum:"1" maximum:"100" default:"20" doc:"Maximum number of messages to return"`
+		AdminToken string `header:"X-Admin-Token" doc:"Admin token"`
+	}) (*dto.DeadLettersOutput, error) {
+		if err := requireAdmin(input.AdminToken); err != nil {
+			return nil, err
+		}
+		letters, total, err := deadLetters.List(input.Limit)
+		if err != nil {
+			return nil, deadLetterError(err)
+		}
+		resp := &dto.DeadLettersOutput{}
+		resp.Body.DeadLetters = letters
+		resp.Body.Total = total
+		return resp, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "get-dead-letter",
+		Summary:     "Inspect a dead-lettered event",
+		Method:      http.MethodGet,
+		Path:        "/admin/dead-letters/{id}",
+		Tags:        []string{"admin"},
+	}, func(ctx context.Context, input *dto.DeadLetterInput) (*dto.DeadLetterOutput, error) {
+		if err := requireAdmin(input.AdminToken); err != nil {
+			return nil, err
+		}
+		letter, err := deadLetters.Get(input.Id)
+		if err != nil {
+			return nil, deadLetterError(err)
+		}
+		return &dto.DeadLetterOutput{Body: *letter}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "redrive-dead-letter",
+		Summary:     "Re-drive a dead-lettered event",
+		Description: "Sends the event back to the consumer queue with a fresh attempt count and removes it from the dead-letter queue.",
+		Method:      http.MethodPost,
+		Path:        "/admin/dead-letters/{id}:redrive",
+		Tags:        []string{"admin"},
+	}, func(ctx context.Context, input *dto.DeadLetterInput) (*dto.DeadLetterOutput, error) {
+		if err := requireAdmin(input.AdminToken); err != nil {
+			return nil, err
+		}
+		letter, err := deadLetters.Redrive(ctx, input.Id)
+		if err != nil {
+			return nil, deadLetterError(err)
+		}
+		return &dto.DeadLetterOutput{Body: *letter}, nil
+	})
+}
@@ -0,0 +1,116 @@
+package operation_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/operation"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/rabbitmq"
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/danielgtaylor/huma/v2/humatest"
+	"github.com/go-chi/chi/v5"
+)
+
+// memoryDeadLetters is an in-memory operation.DeadLetterQueue
+type memoryDeadLetters struct {
+	letters   []rabbitmq.DeadLetter
+	redriven  []string
+	connected bool
+}
+
+func (q *memoryDeadLetters) List(limit int) ([]rabbitmq.DeadLetter, int, error) {
+	if !q.connected {
+		return nil, 0, rabbitmq.ErrNotConnected
+	}
+	return q.letters[:min(limit, len(q.letters))], len(q.letters), nil
+}
+
+func (q *memoryDeadLetters) Get(id string) (*rabbitmq.DeadLetter, error) {
+	for _, letter := range q.letters {
+		if letter.ID == id {
+			return &letter, nil
+		}
+	}
+	return nil, rabbitmq.ErrDeadLetterNotFound
+}
+
+func (q *memoryDeadLetters) Redrive(ctx context.Context, id string) (*rabbitmq.DeadLetter, error) {
+	letter, err := q.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	q.redriven = append(q.redriven, id)
+	return letter, nil
+}
+
+func setupDeadLetterAPI(t *testing.T, deadLetters *memoryDeadLetters) humatest.TestAPI {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	// The chi adapter the service runs on, which routes the {id}:redrive paths
+	api := humatest.Wrap(t, humachi.New(chi.NewMux(), huma.DefaultConfig("Test", "1.0.0")))
+	operation.RegisterDeadLetterRoutes(api, deadLetters)
+	return api
+}
+
+func TestDeadLetterRoutesRequireAdmin(t *testing.T) {
+	api := setupDeadLetterAPI(t, &memoryDeadLetters{connected: true})
+
+	for _, resp := range []int{
+		api.Get("/admin/dead-letters").Code,
+		api.Get("/admin/dead-letters/a1").Code,
+		api.Post("/admin/dead-letters/a1:redrive").Code,
+	} {
+		if resp != http.StatusUnauthorized {
+			t.Errorf("expected 401 without the admin token, got %d", resp)
+		}
+	}
+}
+
+func TestListDeadLetters(t *testing.T) {
+	api := setupDeadLetterAPI(t, &memoryDeadLetters{connected: true, letters: []rabbitmq.DeadLetter{
+		{ID: "a1", RoutingKey: "order.created", Attempts: 5},
+		{ID: "b2", RoutingKey: "product.deleted", Attempts: 3},
+	}})
+
+	resp := api.Get("/admin/dead-letters?limit=1", "X-Admin-Token: secret")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", resp.Code, resp.Body.String())
+	}
+	var body struct {
+		DeadLetters []rabbitmq.DeadLetter `json:"dead_letters"`
+		Total       int                   `json:"total"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &body); err != nil {
+		t.Fatalf("failed to decode body: %v", err)
+	}
+	if len(body.DeadLetters) != 1 || body.DeadLetters[0].ID != "a1" || body.Total != 2 {
+		t.Errorf("expected the first of 2 dead letters, got %+v", body)
+	}
+}
+
+func TestListDeadLettersDisconnected(t *testing.T) {
+	api := setupDeadLetterAPI(t, &memoryDeadLetters{})
+
+	if resp := api.Get("/admin/dead-letters", "X-Admin-Token: secret"); resp.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 while RabbitMQ is unavailable, got %d", resp.Code)
+	}
+}
+
+func TestRedriveDeadLetter(t *testing.T) {
+	deadLetters := &memoryDeadLetters{connected: true, letters: []rabbitmq.DeadLetter{{ID: "a1", RoutingKey: "order.created"}}}
+	api := setupDeadLetterAPI(t, deadLetters)
+
+	if resp := api.Post("/admin/dead-letters/zz:redrive", "X-Admin-Token: secret"); resp.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown dead letter, got %d", resp.Code)
+	}
+
+	resp := api.Post("/admin/dead-letters/a1:redrive", "X-Admin-Token: secret")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", resp.Code, resp.Body.String())
+	}
+	if len(deadLetters.redriven) != 1 || deadLetters.redriven[0] != "a1" {
+		t.Errorf("expected a1 to be re-driven, got %v", deadLetters.redriven)
+	}
+}
@@ -0,0 +1,31 @@
+package operation
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/repository"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// repositoryError maps customer repository errors to HTTP errors. ifMatch is the If-Match
+// header of the request, which decides how a concurrent update is reported.
+func repositoryError(err error, ifMatch []string) error {
+	var conflict *repository.ConflictError
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		return huma.NewError(http.StatusNotFound, "Customer not found")
+	case errors.Is(err, repository.ErrStale):
+		return concurrentUpdateError(ifMatch)
+	case errors.As(err, &conflict) && conflict.Field == "":
+		return huma.NewError(http.StatusConflict, "Customer conflicts with an existing customer")
+	case errors.As(err, &conflict):
+		return huma.NewError(http.StatusConflict, "Customer "+conflict.Field+" is already taken", &huma.ErrorDetail{
+			Location: "body." + conflict.Field,
+			Message:  "already taken",
+			Value:    conflict.Value,
+		})
+	default:
+		return err
+	}
+}
@@ -0,0 +1,53 @@
+package operation
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// customerETag returns the strong ETag of a customer for a given version
+func customerETag(version uint) string {
+	return `"` + strconv.FormatUint(uint64(version), 10) + `"`
+}
+
+// etagMatches reports whether one of the values of an If-Match / If-None-Match
+// header matches etag. If-Match uses the strong comparison (weak validators never
+// match) while If-None-Match uses the weak one (RFC 9110 section 13.1).
+func etagMatches(values []string, etag string, weak bool) bool {
+	for _, value := range values {
+		for _, candidate := range strings.Split(value, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if weak {
+				candidate = strings.TrimPrefix(candidate, "W/")
+			}
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkIfMatch fails with 412 Precondition Failed when If-Match was sent and does not match etag
+func checkIfMatch(ifMatch []string, etag string) error {
+	if len(ifMatch) == 0 || etagMatches(ifMatch, etag, false) {
+		return nil
+	}
+	return huma.NewError(http.StatusPreconditionFailed, "Customer has been modified, current ETag is "+etag)
+}
+
+// notModified reports whether a GET can be answered with 304 Not Modified
+func notModified(ifNoneMatch []string, etag string) bool {
+	return len(ifNoneMatch) > 0 && etagMatches(ifNoneMatch, etag, true)
+}
+
+// concurrentUpdateError is returned when the customer changed between its read and its write
+func concurrentUpdateError(ifMatch []string) error {
+	if len(ifMatch) > 0 {
+		return huma.NewError(http.StatusPreconditionFailed, "Customer has been modified concurrently")
+	}
+	return huma.NewError(http.StatusConflict, "Customer has been modified concurrently, please retry")
+}
@@ -0,0 +1,239 @@
+package operation
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/PayeTonKawa-EPSI-2025/Common-V2/models"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/dto"
+	localModels "github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/models"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/repository"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// ordersFromService reads ORDERS_FROM_SERVICE, to be set while the local order projection
+// misses orders, e.g. until it has been fed since the first order. Orders are then fetched
+// from the Orders service, otherwise they are read from the projection.
+func ordersFromService() bool {
+	fromService, _ := strconv.ParseBool(os.Getenv("ORDERS_FROM_SERVICE"))
+	return fromService
+}
+
+// localCustomerOrders reads the orders of a customer from the local projection
+func (s *CustomerService) localCustomerOrders(ctx context.Context, customerID uint) ([]dto.ExpandedOrder, error) {
+	projected, err := s.repo.CustomerOrders(ctx, customerID, repository.OrderFilter{})
+	if err != nil {
+		return nil, err
+	}
+
+	orders := make([]dto.ExpandedOrder, 0, len(projected))
+	for _, order := range projected {
+		orders = append(orders, toOrder(order, customerID))
+	}
+	return orders, nil
+}
+
+// toOrder converts a projected order to an order attached to its customer
+func toOrder(order localModels.Order, customerID uint) dto.ExpandedOrder {
+	o := dto.ExpandedOrder{
+		Order:  models.Order{CustomerID: customerID},
+		Status: order.Status,
+		Total:  order.Total,
+	}
+	o.ID = order.ID
+	o.CreatedAt = order.OrderedAt
+	o.UpdatedAt = order.UpdatedAt
+	for _, productID := range order.ProductIDs {
+		product := models.Product{}
+		product.ID = productID
+		o.Products = append(o.Products, product)
+	}
+	return o
+}
+
+// customerOrders returns the orders of a customer from the local projection, or from the
+// Orders service when ORDERS_FROM_SERVICE is set. When the service cannot be reached the
+// projection is used anyway, partial orders being better than none, and degraded is set.
+func (s *CustomerService) customerOrders(ctx context.Context, customerID uint) (list []dto.ExpandedOrder, degraded bool) {
+	if ordersFromService() {
+		fetched, err := s.orders.CustomerOrders(ctx, customerID)
+		if err == nil {
+			list = make([]dto.ExpandedOrder, 0, len(fetched))
+			for _, order := range fetched {
+				list = append(list, dto.ExpandedOrder{Order: order})
+			}
+			return list, false
+		}
+		log.Printf("Failed to fetch orders of customer %d, using local projection: %v", customerID, err)
+		degraded = true
+	}
+
+	list, err := s.localCustomerOrders(ctx, customerID)
+	if err != nil {
+		log.Printf("Failed to read orders of customer %d: %v", customerID, err)
+		return nil, true
+	}
+	return list, degraded
+}
+
+// ordersCursorSort tags the cursors of order lists, which are sorted by date, newest first
+const ordersCursorSort = "-ordered_at"
+
+// orderFromProjection converts a projected order for the orders endpoint
+func orderFromProjection(order localModels.Order, customerID uint) dto.CustomerOrder {
+	return dto.CustomerOrder{
+		ID:         order.ID,
+		CustomerID: customerID,
+		Status:     order.Status,
+		Total:      order.Total,
+		OrderedAt:  order.OrderedAt,
+		ProductIDs: order.ProductIDs,
+	}
+}
+
+// orderFromService converts an order returned by the Orders service for the orders endpoint
+func orderFromService(order models.Order) dto.CustomerOrder {
+	o := dto.CustomerOrder{
+		ID:         order.ID,
+		CustomerID: order.CustomerID,
+		OrderedAt:  order.CreatedAt,
+	}
+	for _, product := range order.Products {
+		o.ProductIDs = append(o.ProductIDs, product.ID)
+	}
+	return o
+}
+
+// projectedOrdersPage reads a page of orders from the local projection, one more than
+// the limit to tell whether there is a next page
+func (s *CustomerService) projectedOrdersPage(ctx context.Context, input *dto.CustomerOrdersInput, cursor *listCursor, cursorTime time.Time) ([]dto.CustomerOrder, error) {
+	filter := repository.OrderFilter{
+		OrderedAfter:  input.OrderedAfter,
+		OrderedBefore: input.OrderedBefore,
+		Limit:         input.Limit + 1,
+	}
+	if cursor != nil {
+		filter.Before = &repository.Position{Value: cursorTime, ID: cursor.ID}
+	}
+
+	projected, err := s.repo.CustomerOrders(ctx, input.Id, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	page := make([]dto.CustomerOrder, 0, len(projected))
+	for _, order := range projected {
+		page = append(page, orderFromProjection(order, input.Id))
+	}
+	return page, nil
+}
+
+// serviceOrdersPage applies the filters and the pagination to the orders returned by the
+// Orders service, which returns all of them at once
+func serviceOrdersPage(fetched []models.Order, input *dto.CustomerOrdersInput, cursor *listCursor, cursorTime time.Time) []dto.CustomerOrder {
+	page := []dto.CustomerOrder{}
+	for _, order := range fetched {
+		o := orderFromService(order)
+		if !input.OrderedAfter.IsZero() && !o.OrderedAt.After(input.OrderedAfter) {
+			continue
+		}
+		if !input.OrderedBefore.IsZero() && !o.OrderedAt.Before(input.OrderedBefore) {
+			continue
+		}
+		if cursor != nil && !(o.OrderedAt.Before(cursorTime) || (o.OrderedAt.Equal(cursorTime) && o.ID < cursor.ID)) {
+			continue
+		}
+		page = append(page, o)
+	}
+
+	slices.SortFunc(page, func(a, b dto.CustomerOrder) int {
+		if c := b.OrderedAt.Compare(a.OrderedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.ID, a.ID)
+	})
+	return page[:min(len(page), input.Limit+1)]
+}
+
+// List the orders of a customer, newest first
+func (s *CustomerService) GetCustomerOrders(ctx context.Context, input *dto.CustomerOrdersInput) (*dto.CustomerOrdersOutput, error) {
+	resp := &dto.CustomerOrdersOutput{}
+
+	if input.Limit <= 0 {
+		input.Limit = 20
+	}
+	resp.Body.Limit = input.Limit
+	resp.Body.Cursor = input.Cursor
+
+	if _, err := s.repo.Get(ctx, input.Id, false); err != nil {
+		return nil, repositoryError(err, nil)
+	}
+
+	var cursor *listCursor
+	var cursorTime time.Time
+	if input.Cursor != "" {
+		c, err := decodeCursor(input.Cursor)
+		if err == nil && c.Sort == ordersCursorSort {
+			cursorTime, err = time.Parse(time.RFC3339Nano, c.Value)
+		}
+		if err != nil || c.Sort != ordersCursorSort {
+			return nil, huma.NewError(http.StatusBadRequest, "Invalid cursor")
+		}
+		cursor = &c
+	}
+
+	var page []dto.CustomerOrder
+	fromProjection := !ordersFromService()
+	if !fromProjection {
+		fetched, err := s.orders.CustomerOrders(ctx, input.Id)
+		if err == nil {
+			page = serviceOrdersPage(fetched, input, cursor, cursorTime)
+		} else {
+			log.Printf("Failed to fetch orders of customer %d, using local projection: %v", input.Id, err)
+			resp.Body.OrdersDegraded = true
+			fromProjection = true
+		}
+	}
+	if fromProjection {
+		var err error
+		if page, err = s.projectedOrdersPage(ctx, input, cursor, cursorTime); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(page) > input.Limit {
+		page = page[:input.Limit]
+		last := page[len(page)-1]
+		resp.Body.NextCursor = encodeCursor(listCursor{
+			Sort:  ordersCursorSort,
+			Value: last.OrderedAt.UTC().Format(time.RFC3339Nano),
+			ID:    last.ID,
+		})
+		resp.Link = nextOrdersPageLink(input, resp.Body.NextCursor)
+	}
+
+	resp.Body.Orders = page
+	return resp, nil
+}
+
+// nextOrdersPageLink builds the RFC 8288 Link header pointing to the next page of orders
+func nextOrdersPageLink(input *dto.CustomerOrdersInput, nextCursor string) string {
+	params := url.Values{}
+	params.Set("limit", strconv.Itoa(input.Limit))
+	params.Set("cursor", nextCursor)
+	if !input.OrderedAfter.IsZero() {
+		params.Set("ordered_after", input.OrderedAfter.Format(time.RFC3339Nano))
+	}
+	if !input.OrderedBefore.IsZero() {
+		params.Set("ordered_before", input.OrderedBefore.Format(time.RFC3339Nano))
+	}
+	return fmt.Sprintf(`</customers/%d/orders?%s>; rel="next"`, input.Id, params.Encode())
+}
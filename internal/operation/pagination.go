@@ -0,0 +1,138 @@
+package operation
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PayeTonKawa-EPSI-2025/Common-V2/models"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/dto"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/repository"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// sortColumns maps the public sort fields to the repository sort fields
+var sortColumns = map[string]string{
+	"username":   repository.SortByUsername,
+	"name":       repository.SortByName,
+	"created_at": repository.SortByCreatedAt,
+}
+
+// listCursor is the decoded form of the opaque cursor handed to clients.
+// It remembers the sort used and the position of the last customer returned.
+type listCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    uint   `json:"id"`
+}
+
+func encodeCursor(c listCursor) string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(s string) (listCursor, error) {
+	var c listCursor
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(raw, &c)
+	return c, err
+}
+
+// parseSort splits a sort parameter such as "-name" into its column and direction
+func parseSort(sort string) (column string, desc bool, err error) {
+	if sort == "" {
+		sort = "created_at"
+	}
+	field := strings.TrimPrefix(sort, "-")
+	column, ok := sortColumns[field]
+	if !ok {
+		return "", false, huma.NewError(http.StatusBadRequest, fmt.Sprintf("Invalid sort field %q", field))
+	}
+	return column, strings.HasPrefix(sort, "-"), nil
+}
+
+// cursorValue returns the value of the sort column for a customer, as stored in a cursor
+func cursorValue(c models.Customer, column string) string {
+	switch column {
+	case repository.SortByUsername:
+		return c.Username
+	case repository.SortByName:
+		return c.Name
+	default:
+		return c.CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+}
+
+// customerListFilter converts the list parameters to a repository filter. One more
+// customer than the limit is asked for to tell whether there is a next page.
+func customerListFilter(input *dto.CustomersListInput, column string, desc bool) repository.ListFilter {
+	return repository.ListFilter{
+		City:           input.City,
+		PostalCode:     input.PostalCode,
+		CompanyName:    input.CompanyName,
+		CreatedAfter:   input.CreatedAfter,
+		CreatedBefore:  input.CreatedBefore,
+		IncludeDeleted: input.IncludeDeleted,
+		Sort:           column,
+		Desc:           desc,
+		Limit:          input.Limit + 1,
+	}
+}
+
+// cursorPosition decodes the cursor into the position of the last customer returned,
+// the next page starting right after it
+func cursorPosition(input *dto.CustomersListInput, column string) (*repository.Position, error) {
+	if input.Cursor == "" {
+		return nil, nil
+	}
+
+	cursor, err := decodeCursor(input.Cursor)
+	if err != nil || cursor.Sort != input.Sort {
+		return nil, huma.NewError(http.StatusBadRequest, "Invalid cursor")
+	}
+
+	var value any = cursor.Value
+	if column == repository.SortByCreatedAt {
+		t, err := time.Parse(time.RFC3339Nano, cursor.Value)
+		if err != nil {
+			return nil, huma.NewError(http.StatusBadRequest, "Invalid cursor")
+		}
+		value = t
+	}
+	return &repository.Position{Value: value, ID: cursor.ID}, nil
+}
+
+// nextPageLink builds the RFC 8288 Link header pointing to the next page
+func nextPageLink(input *dto.CustomersListInput, nextCursor string) string {
+	params := url.Values{}
+	params.Set("limit", strconv.Itoa(input.Limit))
+	params.Set("sort", input.Sort)
+	params.Set("cursor", nextCursor)
+	if input.City != "" {
+		params.Set("city", input.City)
+	}
+	if input.PostalCode != "" {
+		params.Set("postal_code", input.PostalCode)
+	}
+	if input.CompanyName != "" {
+		params.Set("company_name", input.CompanyName)
+	}
+	if !input.CreatedAfter.IsZero() {
+		params.Set("created_after", input.CreatedAfter.Format(time.RFC3339Nano))
+	}
+	if !input.CreatedBefore.IsZero() {
+		params.Set("created_before", input.CreatedBefore.Format(time.RFC3339Nano))
+	}
+	if input.IncludeDeleted {
+		params.Set("include_deleted", "true")
+	}
+	return fmt.Sprintf(`</customers?%s>; rel="next"`, params.Encode())
+}
@@ -0,0 +1,197 @@
+package operation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/PayeTonKawa-EPSI-2025/Common-V2/events"
+	"github.com/PayeTonKawa-EPSI-2025/Common-V2/models"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/dto"
+	"github.com/danielgtaylor/huma/v2"
+	jsonpatch "github.com/evanphx/json-patch/v5"
+)
+
+const (
+	mergePatchContentType = "application/merge-patch+json"
+	jsonPatchContentType  = "application/json-patch+json"
+)
+
+// customerDocument returns the patchable representation of a customer.
+// Patches are applied to the same shape as the PUT body.
+func customerDocument(c models.Customer) dto.CustomerCreateBody {
+	return dto.CustomerCreateBody{
+		Username:  c.Username,
+		FirstName: c.FirstName,
+		LastName:  c.LastName,
+		Address:   c.Address,
+		Company:   c.Company,
+	}
+}
+
+// applyPatch applies a JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902) to doc
+func applyPatch(contentType string, doc, patch []byte) ([]byte, error) {
+	mediaType := mergePatchContentType
+	if contentType != "" {
+		parsed, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return nil, huma.NewError(http.StatusUnsupportedMediaType, "Invalid Content-Type")
+		}
+		mediaType = parsed
+	}
+
+	switch mediaType {
+	case mergePatchContentType, "application/json":
+		patched, err := jsonpatch.MergePatch(doc, patch)
+		if err != nil {
+			return nil, huma.NewError(http.StatusUnprocessableEntity, "Unable to apply merge patch", err)
+		}
+		return patched, nil
+	case jsonPatchContentType:
+		ops, err := jsonpatch.DecodePatch(patch)
+		if err != nil {
+			return nil, huma.NewError(http.StatusBadRequest, "Invalid JSON patch", err)
+		}
+		patched, err := ops.Apply(doc)
+		if err != nil {
+			return nil, huma.NewError(http.StatusUnprocessableEntity, "Unable to apply JSON patch", err)
+		}
+		return patched, nil
+	default:
+		return nil, huma.NewError(http.StatusUnsupportedMediaType,
+			"Content-Type must be "+mergePatchContentType+" or "+jsonPatchContentType)
+	}
+}
+
+// diffCustomer applies the patched document to the current customer and returns the
+// updated customer, the columns to write and the public names of the fields that changed.
+// Name normalisation only runs on the fields that were actually touched.
+func diffCustomer(current models.Customer, patched dto.CustomerCreateBody) (models.Customer, []string, []string) {
+	updated := current
+	columns := []string{}
+	changed := []string{}
+
+	if patched.Username != current.Username {
+		updated.Username = patched.Username
+		columns = append(columns, "username")
+		changed = append(changed, "username")
+	}
+
+	if patched.FirstName != current.FirstName {
+		if normalized := normalizeFirstName(patched.FirstName); normalized != current.FirstName {
+			updated.FirstName = normalized
+			updated.Profile.FirstName = normalized
+			columns = append(columns, "first_name", "profile_first_name")
+			changed = append(changed, "firstname")
+		}
+	}
+	if patched.LastName != current.LastName {
+		if normalized := normalizeLastName(patched.LastName); normalized != current.LastName {
+			updated.LastName = normalized
+			updated.Profile.LastName = normalized
+			columns = append(columns, "last_name", "profile_last_name")
+			changed = append(changed, "lastname")
+		}
+	}
+	if updated.FirstName != current.FirstName || updated.LastName != current.LastName {
+		updated.Name = updated.FirstName + " " + updated.LastName
+		columns = append(columns, "name")
+	}
+
+	if patched.Address.PostalCode != current.Address.PostalCode {
+		updated.Address.PostalCode = patched.Address.PostalCode
+		columns = append(columns, "address_postal_code")
+		changed = append(changed, "address.postalCode")
+	}
+	if patched.Address.City != current.Address.City {
+		updated.Address.City = patched.Address.City
+		columns = append(columns, "address_city")
+		changed = append(changed, "address.city")
+	}
+	if patched.Company.CompanyName != current.Company.CompanyName {
+		updated.Company.CompanyName = patched.Company.CompanyName
+		columns = append(columns, "company_company_name")
+		changed = append(changed, "company.companyName")
+	}
+
+	return updated, columns, changed
+}
+
+// validatePatched validates the patched document. Only the changed fields are checked
+// so that a customer stored before validation existed can still be patched.
+func validatePatched(patched dto.CustomerCreateBody, changed []string) error {
+	var errs []error
+	for _, err := range patched.Validate(huma.NewPathBuffer([]byte("body"), len("body"))) {
+		var detail *huma.ErrorDetail
+		if errors.As(err, &detail) && slices.Contains(changed, strings.TrimPrefix(detail.Location, "body.")) {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return huma.NewError(http.StatusUnprocessableEntity, "Patched customer is invalid", errs...)
+	}
+	return nil
+}
+
+// Partially update a customer with a merge patch or a JSON patch
+func (s *CustomerService) PatchCustomer(ctx context.Context, id uint, ifMatch []string, contentType string, patch []byte) (*dto.CustomerOutput, error) {
+	resp := &dto.CustomerOutput{}
+
+	customer, err := s.repo.Get(ctx, id, false)
+	if err != nil {
+		return nil, repositoryError(err, nil)
+	}
+
+	if err := checkIfMatch(ifMatch, customerETag(customer.Version)); err != nil {
+		return nil, err
+	}
+
+	doc, err := json.Marshal(customerDocument(customer.Customer))
+	if err != nil {
+		return nil, err
+	}
+
+	patchedDoc, err := applyPatch(contentType, doc, patch)
+	if err != nil {
+		return nil, err
+	}
+
+	var patched dto.CustomerCreateBody
+	decoder := json.NewDecoder(bytes.NewReader(patchedDoc))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&patched); err != nil {
+		return nil, huma.NewError(http.StatusUnprocessableEntity, "Patched customer is invalid", err)
+	}
+
+	patched.Normalize()
+	updated, columns, changed := diffCustomer(customer.Customer, patched)
+	if err := validatePatched(patched, changed); err != nil {
+		return nil, err
+	}
+	if len(changed) == 0 {
+		resp.ETag = customerETag(customer.Version)
+		resp.Body.Customer = customer.Customer
+		return resp, nil
+	}
+
+	// Only write if nobody updated the customer since we read it
+	customer.Customer = updated
+	err = s.repo.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.repo.Update(ctx, &customer, columns...); err != nil {
+			return err
+		}
+		return s.publish(ctx, events.CustomerUpdated, customer.Customer, changed)
+	})
+	if err != nil {
+		return nil, repositoryError(err, ifMatch)
+	}
+
+	resp.ETag = customerETag(customer.Version)
+	resp.Body.Customer = customer.Customer
+	return resp, nil
+}
@@ -0,0 +1,74 @@
+package operation
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/dto"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/replay"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// replayRunning prevents two replays from running at once on this instance
+var replayRunning sync.Mutex
+
+// RegisterReplayRoutes registers the admin routes republishing existing customers.
+// Replays run in the background until they complete or serverCtx is done, an interrupted
+// replay resuming from its checkpoint the next time.
+func RegisterReplayRoutes(serverCtx context.Context, api huma.API, replayer *replay.Replayer) {
+	huma.Register(api, huma.Operation{
+		OperationID:   "replay-customers",
+		Summary:       "Republish all customers",
+		Description:   "Starts republishing every customer as a customer.snapshot event in the background. The replay resumes from its checkpoint unless restart is set.",
+		Method:        http.MethodPost,
+		Path:          "/admin/replay-customers",
+		DefaultStatus: http.StatusAccepted,
+		Tags:          []string{"admin"},
+	}, func(ctx context.Context, input *dto.ReplayInput) (*dto.ReplayOutput, error) {
+		if err := requireAdmin(input.AdminToken); err != nil {
+			return nil, err
+		}
+		if !replayRunning.TryLock() {
+			return nil, huma.NewError(http.StatusConflict, "A replay is already running")
+		}
+
+		opts := input.Body
+		go func() {
+			defer replayRunning.Unlock()
+			if _, err := replayer.Run(serverCtx, opts); err != nil {
+				log.Printf("Replay %s failed: %v", opts.Name, err)
+			}
+		}()
+		return &dto.ReplayOutput{Body: opts}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "get-replay-customers",
+		Summary:     "Get the progress of a customer replay",
+		Method:      http.MethodGet,
+		Path:        "/admin/replay-customers",
+		Tags:        []string{"admin"},
+	}, func(ctx context.Context, input *struct {
+		AdminToken string `header:"X-Admin-Token" doc:"Admin token"`
+		Name       string `query:"name" default:"customers" doc:"Checkpoint name"`
+	}) (*dto.ReplayStatusOutput, error) {
+		if err := requireAdmin(input.AdminToken); err != nil {
+			return nil, err
+		}
+		checkpoint, err := replayer.Status(ctx, input.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		resp := &dto.ReplayStatusOutput{}
+		resp.Body.Checkpoint = checkpoint
+		if replayRunning.TryLock() {
+			replayRunning.Unlock()
+		} else {
+			resp.Body.Running = true
+		}
+		return resp, nil
+	})
+}
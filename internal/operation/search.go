@@ -0,0 +1,48 @@
+package operation
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/dto"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// searchTerms splits free text into the terms to search: "hél dup" -> ["hél", "dup"].
+// Everything but letters and digits is dropped so user input cannot inject tsquery operators.
+func searchTerms(q string) []string {
+	return strings.FieldsFunc(q, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Search customers by fragments of name, username, company or city
+func (s *CustomerService) SearchCustomers(ctx context.Context, input *dto.CustomerSearchInput) (*dto.CustomerSearchOutput, error) {
+	resp := &dto.CustomerSearchOutput{}
+
+	terms := searchTerms(input.Q)
+	if len(terms) == 0 {
+		return nil, huma.NewError(http.StatusBadRequest, "Search query must contain at least one letter or digit")
+	}
+	if input.Limit <= 0 {
+		input.Limit = 20
+	}
+
+	rows, err := s.repo.Search(ctx, terms, input.Limit)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Body.Results = make([]dto.CustomerSearchResult, 0, len(rows))
+	for _, row := range rows {
+		resp.Body.Results = append(resp.Body.Results, dto.CustomerSearchResult{
+			Customer: row.Customer,
+			Rank:     row.Rank,
+			Snippet:  row.Snippet,
+		})
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,72 @@
+package operation
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/PayeTonKawa-EPSI-2025/Common-V2/events"
+	"github.com/PayeTonKawa-EPSI-2025/Common-V2/models"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/rabbitmq"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/repository"
+)
+
+// Clock returns the current time
+type Clock func() time.Time
+
+// IDGenerator returns a new unique identifier. Event IDs are also published as the
+// CloudEvent id and AMQP message ID that consumers deduplicate on.
+type IDGenerator func() string
+
+// NewEventID returns a random event ID
+func NewEventID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// EventPublisher publishes customer events. Events published with the context of a
+// repository transaction must only go out if the transaction commits.
+type EventPublisher interface {
+	PublishCustomerEvent(ctx context.Context, event rabbitmq.CustomerChangeEvent) error
+}
+
+// OrdersClient fetches the orders of a customer from the Orders service
+type OrdersClient interface {
+	CustomerOrders(ctx context.Context, customerID uint) ([]models.Order, error)
+}
+
+// CustomerService implements the customer operations on top of its collaborators
+type CustomerService struct {
+	repo      repository.CustomerRepository
+	publisher EventPublisher
+	orders    OrdersClient
+	now       Clock
+	newID     IDGenerator
+}
+
+// NewCustomerService creates a service storing customers in repo, publishing their
+// changes with publisher and fetching their orders with orders
+func NewCustomerService(repo repository.CustomerRepository, publisher EventPublisher, orders OrdersClient, now Clock, newID IDGenerator) *CustomerService {
+	return &CustomerService{
+		repo:      repo,
+		publisher: publisher,
+		orders:    orders,
+		now:       now,
+		newID:     newID,
+	}
+}
+
+// publish publishes a customer event, listing the changed fields of a partial update
+func (s *CustomerService) publish(ctx context.Context, eventType events.EventType, customer models.Customer, changedFields []string) error {
+	return s.publisher.PublishCustomerEvent(ctx, rabbitmq.CustomerChangeEvent{
+		CustomerEvent: events.CustomerEvent{
+			Type:      eventType,
+			Customer:  customer,
+			Timestamp: s.now(),
+		},
+		ID:            s.newID(),
+		ChangedFields: changedFields,
+	})
+}
@@ -0,0 +1,60 @@
+package orders
+
+import (
+	"sync"
+	"time"
+)
+
+// breaker is a circuit breaker opening after threshold consecutive failures.
+// Once cooldown has elapsed a single trial call is let through: its success closes
+// the circuit, its failure opens it for another cooldown.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	trial     bool
+}
+
+// allow reports whether a call may go through
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return true
+	}
+	if time.Now().Before(b.openUntil) || b.trial {
+		return false
+	}
+	b.trial = true
+	return true
+}
+
+// record updates the breaker with the outcome of a call
+func (b *breaker) record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trial = false
+	if success {
+		b.failures = 0
+		circuitOpen.Set(0)
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+		circuitOpen.Set(1)
+	}
+}
+
+// abandon ends a call whose outcome says nothing about the service, such as one
+// canceled by the caller, freeing the trial slot without counting it
+func (b *breaker) abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
@@ -0,0 +1,180 @@
+// Package orders is the client of the Orders service
+package orders
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/PayeTonKawa-EPSI-2025/Common-V2/models"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrCircuitOpen is returned without calling the Orders service while it is considered down
+var ErrCircuitOpen = errors.New("orders service circuit open")
+
+var circuitOpen = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "orders_circuit_open",
+	Help: "Whether calls to the Orders service are currently short-circuited (1) or not (0)",
+})
+
+func init() {
+	prometheus.MustRegister(circuitOpen)
+}
+
+// Config tunes the Orders client
+type Config struct {
+	BaseURL string
+	// Timeout applies to every attempt
+	Timeout time.Duration
+	// Retries is the number of attempts after the first one
+	Retries int
+	// Backoff is the delay before the first retry, doubled for each following one
+	Backoff time.Duration
+	// FailureThreshold is the number of consecutive failed calls opening the circuit
+	FailureThreshold int
+	// Cooldown is how long the circuit stays open before a trial call
+	Cooldown time.Duration
+}
+
+// ConfigFromEnv reads ORDERS_URL, ORDERS_TIMEOUT (default 2s), ORDERS_RETRIES (default 2),
+// ORDERS_BREAKER_THRESHOLD (default 5) and ORDERS_BREAKER_COOLDOWN (default 30s)
+func ConfigFromEnv() Config {
+	cfg := Config{
+		BaseURL:          os.Getenv("ORDERS_URL"),
+		Timeout:          2 * time.Second,
+		Retries:          2,
+		Backoff:          100 * time.Millisecond,
+		FailureThreshold: 5,
+		Cooldown:         30 * time.Second,
+	}
+	if value, err := time.ParseDuration(os.Getenv("ORDERS_TIMEOUT")); err == nil && value > 0 {
+		cfg.Timeout = value
+	}
+	if value, err := strconv.Atoi(os.Getenv("ORDERS_RETRIES")); err == nil && value >= 0 {
+		cfg.Retries = value
+	}
+	if value, err := strconv.Atoi(os.Getenv("ORDERS_BREAKER_THRESHOLD")); err == nil && value > 0 {
+		cfg.FailureThreshold = value
+	}
+	if value, err := time.ParseDuration(os.Getenv("ORDERS_BREAKER_COOLDOWN")); err == nil && value > 0 {
+		cfg.Cooldown = value
+	}
+	return cfg
+}
+
+// Client calls the Orders service
+type Client struct {
+	cfg     Config
+	http    *http.Client
+	breaker *breaker
+}
+
+// NewClient creates an Orders client
+func NewClient(cfg Config) *Client {
+	return &Client{
+		cfg:     cfg,
+		http:    &http.Client{},
+		breaker: &breaker{threshold: max(cfg.FailureThreshold, 1), cooldown: cfg.Cooldown},
+	}
+}
+
+// statusError is a non-2xx answer of the Orders service
+type statusError struct {
+	status int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("orders API returned status %d", e.status)
+}
+
+// retryable tells whether a failed attempt is worth retrying
+func retryable(err error) bool {
+	var status *statusError
+	if errors.As(err, &status) {
+		return status.status >= 500 || status.status == http.StatusTooManyRequests
+	}
+	return !errors.Is(err, context.Canceled)
+}
+
+// CustomerOrders returns the orders of a customer
+func (c *Client) CustomerOrders(ctx context.Context, customerID uint) ([]models.Order, error) {
+	var body struct {
+		Orders []models.Order `json:"orders"`
+	}
+	err := c.get(ctx, fmt.Sprintf("%s/orders/%d/customers", c.cfg.BaseURL, customerID), &body)
+	return body.Orders, err
+}
+
+// get fetches a JSON document, retrying with backoff, through the circuit breaker
+func (c *Client) get(ctx context.Context, url string, v any) error {
+	if !c.breaker.allow() {
+		return ErrCircuitOpen
+	}
+
+	var err error
+	delay := c.cfg.Backoff
+	for attempt := 0; attempt <= c.cfg.Retries; attempt++ {
+		if attempt > 0 {
+			// Full jitter spreads the retries of concurrent requests
+			wait := delay/2 + rand.N(delay/2+1)
+			select {
+			case <-ctx.Done():
+				c.breaker.abandon()
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+			delay *= 2
+		}
+
+		err = c.attempt(ctx, url, v)
+		if err == nil || !retryable(err) {
+			break
+		}
+	}
+
+	// The caller gave up, which tells nothing about the service. Attempts timing out on
+	// their own are failures though.
+	if ctx.Err() != nil {
+		c.breaker.abandon()
+		return err
+	}
+	// A client error means the service is up, only count server side failures
+	c.breaker.record(err == nil || !retryable(err))
+	return err
+}
+
+// attempt performs a single GET bounded by the per-call timeout
+func (c *Client) attempt(ctx context.Context, url string, v any) error {
+	if c.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.cfg.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &statusError{status: resp.StatusCode}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("decoding orders response: %w", err)
+	}
+	return nil
+}
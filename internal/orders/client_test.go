@@ -0,0 +1,152 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testClient(url string) *Client {
+	return NewClient(Config{
+		BaseURL:          url,
+		Timeout:          50 * time.Millisecond,
+		Retries:          2,
+		Backoff:          time.Millisecond,
+		FailureThreshold: 2,
+		Cooldown:         time.Hour,
+	})
+}
+
+func TestCustomerOrdersRetriesServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/orders/7/customers" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"orders":[{"ID":1,"customerId":7}]}`))
+	}))
+	defer server.Close()
+
+	orders, err := testClient(server.URL).CustomerOrders(context.Background(), 7)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(orders) != 1 || orders[0].ID != 1 || calls.Load() != 3 {
+		t.Errorf("got %v after %d calls, want 1 order after 3 calls", orders, calls.Load())
+	}
+}
+
+func TestCustomerOrdersDoesNotRetryClientErrors(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	if _, err := testClient(server.URL).CustomerOrders(context.Background(), 7); err == nil {
+		t.Fatal("expected an error")
+	}
+	if calls.Load() != 1 {
+		t.Errorf("got %d calls, want 1", calls.Load())
+	}
+}
+
+func TestCustomerOrdersTimesOut(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer server.Close()
+
+	start := time.Now()
+	if _, err := testClient(server.URL).CustomerOrders(context.Background(), 7); err == nil {
+		t.Fatal("expected an error")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("call took %s, expected the per-call timeout to apply", elapsed)
+	}
+}
+
+func TestCircuitOpensAfterConsecutiveFailures(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	client := testClient(server.URL)
+	for range 2 {
+		_, _ = client.CustomerOrders(context.Background(), 7)
+	}
+	before := calls.Load()
+
+	_, err := client.CustomerOrders(context.Background(), 7)
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("got %v, want ErrCircuitOpen", err)
+	}
+	if calls.Load() != before {
+		t.Error("expected no call while the circuit is open")
+	}
+}
+
+func TestCallerCancellationIsNotRecorded(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancelExpired := context.WithTimeout(context.Background(), 0)
+	defer cancelExpired()
+
+	// Expired callers do not count as failures
+	client := testClient(server.URL)
+	for range 2 {
+		_, _ = client.CustomerOrders(expired, 7)
+	}
+	if _, err := client.CustomerOrders(context.Background(), 7); errors.Is(err, ErrCircuitOpen) {
+		t.Fatal("expected expired callers not to open the circuit")
+	}
+
+	// Canceled callers do not reset the failures either
+	client = testClient(server.URL)
+	_, _ = client.CustomerOrders(context.Background(), 7)
+	_, _ = client.CustomerOrders(canceled, 7)
+	_, _ = client.CustomerOrders(context.Background(), 7)
+	if _, err := client.CustomerOrders(context.Background(), 7); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("got %v, want ErrCircuitOpen after two failures", err)
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	b := &breaker{threshold: 1, cooldown: time.Millisecond}
+	b.record(false)
+	if b.allow() {
+		t.Fatal("expected the circuit to be open")
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	if !b.allow() {
+		t.Fatal("expected a trial call after the cooldown")
+	}
+	if b.allow() {
+		t.Fatal("expected a single trial call")
+	}
+	b.record(true)
+	if !b.allow() {
+		t.Fatal("expected the circuit to close after a successful trial")
+	}
+}
@@ -0,0 +1,183 @@
+package rabbitmq
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	localModels "github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/models"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	cloudEventsSpecVersion = "1.0"
+	cloudEventsContentType = "application/cloudevents+json"
+
+	// EventSource identifies this service as the producer of customer events
+	EventSource = "customers"
+	// CustomerEventSchema describes the data of customer events, CustomerEventVersion
+	// is bumped on every change of that schema
+	CustomerEventSchema  = "urn:payetonkawa:schema:customer-event:v1"
+	CustomerEventVersion = "1"
+
+	// AMQP header prefix of the CloudEvents attributes in binary mode
+	cloudEventsHeaderPrefix = "cloudEvents:"
+)
+
+// CloudEvent is a CloudEvents 1.0 event in structured mode. dataversion and
+// correlationid are extension attributes.
+type CloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	DataSchema      string          `json:"dataschema,omitempty"`
+	DataVersion     string          `json:"dataversion,omitempty"`
+	CorrelationID   string          `json:"correlationid,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	DataBase64      []byte          `json:"data_base64,omitempty"`
+}
+
+// EventFormat selects how events are laid out in AMQP messages
+type EventFormat string
+
+const (
+	// FormatBinary keeps the legacy JSON as body and carries the CloudEvents attributes in headers,
+	// so consumers unaware of CloudEvents keep working
+	FormatBinary EventFormat = "binary"
+	// FormatStructured sends the whole CloudEvent, data included, as an application/cloudevents+json body
+	FormatStructured EventFormat = "structured"
+)
+
+// EventFormatFromEnv reads EVENT_FORMAT, binary by default
+func EventFormatFromEnv() EventFormat {
+	switch format := EventFormat(os.Getenv("EVENT_FORMAT")); format {
+	case FormatStructured, FormatBinary:
+		return format
+	case "":
+		return FormatBinary
+	default:
+		log.Printf("Unknown EVENT_FORMAT %q, using %s", format, FormatBinary)
+		return FormatBinary
+	}
+}
+
+// newCustomerCloudEvent describes an outbox event as a CloudEvent
+func newCustomerCloudEvent(event localModels.OutboxEvent) CloudEvent {
+	ce := CloudEvent{
+		SpecVersion:     cloudEventsSpecVersion,
+		ID:              outboxMessageID(event),
+		Source:          EventSource,
+		Type:            event.RoutingKey,
+		Time:            event.CreatedAt,
+		DataContentType: "application/json",
+		DataSchema:      CustomerEventSchema,
+		DataVersion:     CustomerEventVersion,
+		CorrelationID:   event.CorrelationID,
+		Data:            event.Payload,
+	}
+
+	var payload struct {
+		Customer struct {
+			ID uint `json:"ID"`
+		} `json:"customer"`
+	}
+	if err := json.Unmarshal(event.Payload, &payload); err == nil && payload.Customer.ID != 0 {
+		ce.Subject = strconv.FormatUint(uint64(payload.Customer.ID), 10)
+	}
+	return ce
+}
+
+// publishing lays the event out as an AMQP message in the given format
+func (ce CloudEvent) publishing(format EventFormat) (amqp.Publishing, error) {
+	msg := amqp.Publishing{
+		DeliveryMode: amqp.Persistent,
+		MessageId:    ce.ID,
+		Timestamp:    ce.Time,
+		Type:         ce.Type,
+		AppId:        ce.Source,
+	}
+
+	if format == FormatStructured {
+		body, err := json.Marshal(ce)
+		if err != nil {
+			return msg, err
+		}
+		msg.ContentType = cloudEventsContentType
+		msg.Body = body
+		return msg, nil
+	}
+
+	msg.ContentType = ce.DataContentType
+	msg.Body = ce.Data
+	msg.Headers = amqp.Table{
+		cloudEventsHeaderPrefix + "specversion": ce.SpecVersion,
+		cloudEventsHeaderPrefix + "id":          ce.ID,
+		cloudEventsHeaderPrefix + "source":      ce.Source,
+		cloudEventsHeaderPrefix + "type":        ce.Type,
+		cloudEventsHeaderPrefix + "time":        ce.Time.UTC().Format(time.RFC3339Nano),
+		cloudEventsHeaderPrefix + "dataschema":  ce.DataSchema,
+		cloudEventsHeaderPrefix + "dataversion": ce.DataVersion,
+	}
+	if ce.Subject != "" {
+		msg.Headers[cloudEventsHeaderPrefix+"subject"] = ce.Subject
+	}
+	if ce.CorrelationID != "" {
+		msg.Headers[cloudEventsHeaderPrefix+"correlationid"] = ce.CorrelationID
+	}
+	return msg, nil
+}
+
+// cloudEventHeader reads a binary mode attribute. Both the "cloudEvents:" prefix of the
+// AMQP binding and the "cloudEvents_" prefix used by JMS-compatible producers are accepted.
+func cloudEventHeader(headers amqp.Table, attribute string) string {
+	for _, prefix := range []string{cloudEventsHeaderPrefix, "cloudEvents_"} {
+		if value, ok := headers[prefix+attribute].(string); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// decodeCloudEvent extracts the CloudEvent attributes and data of a delivery, whether it is
+// in structured or binary mode. ok is false for legacy messages, which are bare JSON events.
+func decodeCloudEvent(d amqp.Delivery) (ce CloudEvent, ok bool) {
+	if specVersion := cloudEventHeader(d.Headers, "specversion"); specVersion != "" {
+		ce = CloudEvent{
+			SpecVersion:     specVersion,
+			ID:              cloudEventHeader(d.Headers, "id"),
+			Source:          cloudEventHeader(d.Headers, "source"),
+			Type:            cloudEventHeader(d.Headers, "type"),
+			Subject:         cloudEventHeader(d.Headers, "subject"),
+			DataContentType: d.ContentType,
+			DataSchema:      cloudEventHeader(d.Headers, "dataschema"),
+			DataVersion:     cloudEventHeader(d.Headers, "dataversion"),
+			CorrelationID:   cloudEventHeader(d.Headers, "correlationid"),
+			Data:            d.Body,
+		}
+		ce.Time, _ = time.Parse(time.RFC3339Nano, cloudEventHeader(d.Headers, "time"))
+		return ce, true
+	}
+
+	if d.ContentType != cloudEventsContentType {
+		// Legacy events carry no specversion, check the body in case the content type is missing
+		var probe struct {
+			SpecVersion string `json:"specversion"`
+		}
+		if json.Unmarshal(d.Body, &probe) != nil || probe.SpecVersion == "" {
+			return CloudEvent{}, false
+		}
+	}
+	if err := json.Unmarshal(d.Body, &ce); err != nil || ce.SpecVersion == "" {
+		return CloudEvent{}, false
+	}
+	if len(ce.Data) == 0 && len(ce.DataBase64) > 0 {
+		ce.Data = ce.DataBase64
+	}
+	return ce, true
+}
@@ -0,0 +1,95 @@
+package rabbitmq
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	localModels "github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/models"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func testOutboxEvent() localModels.OutboxEvent {
+	return localModels.OutboxEvent{
+		ID:            42,
+		RoutingKey:    "customer.updated",
+		Payload:       []byte(`{"type":"customer.updated","customer":{"ID":7},"id":"evt-42"}`),
+		CreatedAt:     time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC),
+		CorrelationID: "req-1",
+		MessageID:     "evt-42",
+	}
+}
+
+// delivery turns a publishing into the delivery a consumer would receive
+func delivery(msg amqp.Publishing) amqp.Delivery {
+	return amqp.Delivery{
+		Headers:     msg.Headers,
+		ContentType: msg.ContentType,
+		MessageId:   msg.MessageId,
+		Timestamp:   msg.Timestamp,
+		RoutingKey:  "customer.updated",
+		Body:        msg.Body,
+	}
+}
+
+func TestCloudEventRoundTrip(t *testing.T) {
+	for _, format := range []EventFormat{FormatBinary, FormatStructured} {
+		t.Run(string(format), func(t *testing.T) {
+			msg, err := newCustomerCloudEvent(testOutboxEvent()).publishing(format)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			env := newEnvelope(delivery(msg))
+			if env.MessageID != "evt-42" || msg.MessageId != "evt-42" || env.Source != EventSource || env.Type != "customer.updated" {
+				t.Errorf("unexpected attributes: %+v", env)
+			}
+			if env.DataVersion != CustomerEventVersion || env.CorrelationID != "req-1" {
+				t.Errorf("unexpected extensions: %+v", env)
+			}
+			if !json.Valid(env.Body) || string(env.Body) != string(testOutboxEvent().Payload) {
+				t.Errorf("body = %s, want the legacy payload", env.Body)
+			}
+		})
+	}
+}
+
+func TestCloudEventIDIsPayloadID(t *testing.T) {
+	msg, err := newCustomerCloudEvent(testOutboxEvent()).publishing(FormatBinary)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var payload struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(msg.Body, &payload); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ceID := msg.Headers[cloudEventsHeaderPrefix+"id"]; ceID != payload.ID || msg.MessageId != payload.ID {
+		t.Errorf("expected ce-id %v and message ID %q to be the payload ID %q", ceID, msg.MessageId, payload.ID)
+	}
+
+	// Events stored before the outbox kept their ID fall back to one derived from the row
+	event := testOutboxEvent()
+	event.MessageID = ""
+	if ce := newCustomerCloudEvent(event); ce.ID != "customers-outbox-42" {
+		t.Errorf("expected the row ID fallback, got %q", ce.ID)
+	}
+}
+
+func TestCloudEventSubject(t *testing.T) {
+	ce := newCustomerCloudEvent(testOutboxEvent())
+	if ce.Subject != "7" || ce.DataSchema != CustomerEventSchema {
+		t.Errorf("unexpected event: %+v", ce)
+	}
+}
+
+func TestLegacyEventIsAccepted(t *testing.T) {
+	body := []byte(`{"type":"order.created","order":{"orderId":1,"customerId":2}}`)
+	env := newEnvelope(amqp.Delivery{RoutingKey: "order.created", ContentType: "application/json", Body: body})
+
+	if env.Source != "" || string(env.Body) != string(body) {
+		t.Errorf("legacy event was altered: %+v", env)
+	}
+}
@@ -1,26 +1,211 @@
 package rabbitmq
 
 import (
+	"context"
+	"errors"
 	"log"
-	"os"
+	"math/rand/v2"
+	"sync"
+	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
-func Connect() (*amqp.Connection, *amqp.Channel) {
-	dsn := os.Getenv("RABBIT_DSN")
+const (
+	reconnectBaseDelay = 500 * time.Millisecond
+	reconnectMaxDelay  = 30 * time.Second
+)
+
+// ErrNotConnected is returned while the broker is unreachable
+var ErrNotConnected = errors.New("not connected to RabbitMQ")
+
+// ConsumerSetup starts a consumer on a freshly opened channel
+type ConsumerSetup func(ch *amqp.Channel) error
+
+// ConnectionManager owns the RabbitMQ connection. It watches the connection and its
+// channels, and when any of them is lost it reconnects with exponential backoff,
+// re-declares the events exchange, reopens the publishing channel and restarts consumers.
+type ConnectionManager struct {
+	dsn       string
+	consumers []ConsumerSetup
+
+	mu        sync.RWMutex
+	conn      *amqp.Connection
+	publishCh *amqp.Channel
+	returns   chan amqp.Return
+	closed    bool
+
+	// publishMu serialises publishes so a returned message can be matched to its publish
+	publishMu sync.Mutex
+}
+
+// NewConnectionManager creates a manager for the broker at dsn. Nothing is dialed until Start.
+func NewConnectionManager(dsn string) *ConnectionManager {
+	return &ConnectionManager{dsn: dsn}
+}
+
+// AddConsumer registers a consumer to (re)start on a dedicated channel after every connection.
+// It must be called before Start.
+func (m *ConnectionManager) AddConsumer(setup ConsumerSetup) {
+	m.consumers = append(m.consumers, setup)
+}
+
+// Start connects in the background and keeps the connection alive until ctx is done or
+// Close is called. The service keeps running while the broker is unreachable.
+func (m *ConnectionManager) Start(ctx context.Context) {
+	go m.run(ctx)
+}
+
+// PublishChannel returns the current publishing channel, in confirm mode, or nil while disconnected
+func (m *ConnectionManager) PublishChannel() *amqp.Channel {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.publishCh
+}
+
+// Connected reports whether the publishing channel is open
+func (m *ConnectionManager) Connected() bool {
+	return m.PublishChannel() != nil
+}
+
+// Channel opens a new short-lived channel on the current connection.
+// The caller must close it when done.
+func (m *ConnectionManager) Channel() (*amqp.Channel, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.conn == nil || m.conn.IsClosed() {
+		return nil, ErrNotConnected
+	}
+	return m.conn.Channel()
+}
+
+// Close closes the connection and stops reconnecting
+func (m *ConnectionManager) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	m.teardownLocked()
+}
+
+func (m *ConnectionManager) isClosed() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.closed
+}
 
-	conn, err := amqp.Dial(dsn)
+func (m *ConnectionManager) run(ctx context.Context) {
+	attempt := 0
+	for !m.isClosed() {
+		lost, err := m.connect()
+		if err != nil {
+			delay := backoff(attempt)
+			attempt++
+			log.Printf("RabbitMQ connection failed (attempt %d), retrying in %s: %v", attempt, delay, err)
+			select {
+			case <-ctx.Done():
+				m.Close()
+				return
+			case <-time.After(delay):
+			}
+			continue
+		}
+
+		attempt = 0
+		rabbitConnected.Set(1)
+		log.Println("Connected to RabbitMQ")
+
+		select {
+		case <-ctx.Done():
+			m.Close()
+			return
+		case reason := <-lost:
+			rabbitConnected.Set(0)
+			if m.isClosed() {
+				return
+			}
+			log.Printf("RabbitMQ connection lost, reconnecting: %v", reason)
+			m.mu.Lock()
+			m.teardownLocked()
+			m.mu.Unlock()
+			rabbitReconnects.Inc()
+		}
+	}
+}
+
+// connect dials the broker and sets up the exchange, the publishing channel and the consumers.
+// The returned channel receives a value as soon as the connection or one of its channels closes.
+func (m *ConnectionManager) connect() (<-chan *amqp.Error, error) {
+	conn, err := amqp.Dial(m.dsn)
 	if err != nil {
-		log.Fatalf("Erreur de connexion à RabbitMQ : %v", err)
+		return nil, err
+	}
+
+	lost := make(chan *amqp.Error, 1)
+	watch := func(notify chan *amqp.Error) {
+		go func() {
+			reason, ok := <-notify
+			if !ok {
+				reason = amqp.ErrClosed
+			}
+			select {
+			case lost <- reason:
+			default:
+			}
+		}()
 	}
+	watch(conn.NotifyClose(make(chan *amqp.Error, 1)))
 
-	ch, err := conn.Channel()
+	publishCh, err := conn.Channel()
+	if err == nil {
+		err = declareExchange(publishCh)
+	}
+	if err == nil {
+		err = publishCh.Confirm(false)
+	}
 	if err != nil {
-		log.Fatalf("Erreur ouverture channel : %v", err)
+		_ = conn.Close()
+		return nil, err
 	}
+	watch(publishCh.NotifyClose(make(chan *amqp.Error, 1)))
+	returns := publishCh.NotifyReturn(make(chan amqp.Return, 16))
 
-	err = ch.ExchangeDeclare(
+	for _, setup := range m.consumers {
+		ch, err := conn.Channel()
+		if err == nil {
+			err = setup(ch)
+		}
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		watch(ch.NotifyClose(make(chan *amqp.Error, 1)))
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		_ = conn.Close()
+		return nil, errors.New("connection manager closed")
+	}
+	m.conn = conn
+	m.publishCh = publishCh
+	m.returns = returns
+	return lost, nil
+}
+
+// teardownLocked closes the current connection, m.mu must be held
+func (m *ConnectionManager) teardownLocked() {
+	if m.conn != nil && !m.conn.IsClosed() {
+		_ = m.conn.Close()
+	}
+	m.conn = nil
+	m.publishCh = nil
+	m.returns = nil
+}
+
+// declareExchange declares the topic exchange all services publish to
+func declareExchange(ch *amqp.Channel) error {
+	return ch.ExchangeDeclare(
 		"events",
 		"topic",
 		true,
@@ -29,9 +214,20 @@ func Connect() (*amqp.Connection, *amqp.Channel) {
 		false,
 		nil,
 	)
-	if err != nil {
-		log.Fatalf("Erreur déclaration exchange : %v", err)
-	}
+}
 
-	return conn, ch
+// backoff returns the delay before reconnection attempt n, picked at random between half
+// of and the whole backoffCeiling so that replicas do not reconnect in lockstep
+func backoff(attempt int) time.Duration {
+	delay := backoffCeiling(attempt)
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// backoffCeiling returns the longest delay before reconnection attempt n, doubling from
+// reconnectBaseDelay on every attempt up to reconnectMaxDelay
+func backoffCeiling(attempt int) time.Duration {
+	if attempt < 0 || attempt >= 16 {
+		return reconnectMaxDelay
+	}
+	return min(reconnectBaseDelay<<attempt, reconnectMaxDelay)
 }
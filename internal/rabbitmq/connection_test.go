@@ -0,0 +1,44 @@
+package rabbitmq
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffCeiling(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, 500 * time.Millisecond},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{5, 16 * time.Second},
+		{6, reconnectMaxDelay},
+		{15, reconnectMaxDelay},
+		// Large attempts would overflow the shift
+		{64, reconnectMaxDelay},
+	}
+	for _, tt := range tests {
+		if got := backoffCeiling(tt.attempt); got != tt.want {
+			t.Errorf("backoffCeiling(%d) = %s, want %s", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	for _, attempt := range []int{0, 3, 10} {
+		ceiling := backoffCeiling(attempt)
+		seen := map[time.Duration]bool{}
+		for range 100 {
+			delay := backoff(attempt)
+			if delay < ceiling/2 || delay > ceiling {
+				t.Fatalf("backoff(%d) = %s, want between %s and %s", attempt, delay, ceiling/2, ceiling)
+			}
+			seen[delay] = true
+		}
+		if len(seen) < 2 {
+			t.Errorf("expected backoff(%d) to be jittered, always got %v", attempt, seen)
+		}
+	}
+}
@@ -1,131 +1,288 @@
 package rabbitmq
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"log"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
 
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/rabbitmq/envelope"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
 // EventHandler is a function type that processes RabbitMQ events
-type EventHandler func(body []byte) error
+type EventHandler func(ctx context.Context, msg envelope.Envelope) error
 
 // EventRouter routes events to specific handlers based on routing keys
 type EventRouter struct {
-	handlers map[string]EventHandler
+	handlers      map[string]EventHandler
+	listeners     map[string][]EventHandler
+	middlewares   []Middleware
+	policies      map[string]RetryPolicy
+	defaultPolicy RetryPolicy
+
+	mu    sync.Mutex
+	queue string
 }
 
 // NewEventRouter creates a new event router
 func NewEventRouter() *EventRouter {
 	return &EventRouter{
-		handlers: make(map[string]EventHandler),
+		handlers:      make(map[string]EventHandler),
+		listeners:     make(map[string][]EventHandler),
+		policies:      make(map[string]RetryPolicy),
+		defaultPolicy: DefaultRetryPolicy(),
 	}
 }
 
-// RegisterHandler registers a handler for a specific routing key (event type)
-func (r *EventRouter) RegisterHandler(routingKey string, handler EventHandler) {
-	r.handlers[routingKey] = handler
+// RegisterHandler registers the handler for a routing key pattern using AMQP topic
+// syntax (* matches one word, # zero or more). When several patterns match a message,
+// only the handler of the most specific one runs, see moreSpecific.
+func (r *EventRouter) RegisterHandler(pattern string, handler EventHandler) {
+	r.handlers[pattern] = handler
+}
+
+// AddListener registers a handler that receives every message matching the pattern,
+// in addition to the handler selected by RegisterHandler
+func (r *EventRouter) AddListener(pattern string, handler EventHandler) {
+	r.listeners[pattern] = append(r.listeners[pattern], handler)
+}
+
+// patterns returns every pattern the router needs messages for
+func (r *EventRouter) patterns() []string {
+	seen := map[string]bool{}
+	var patterns []string
+	for pattern := range r.handlers {
+		seen[pattern] = true
+		patterns = append(patterns, pattern)
+	}
+	for pattern := range r.listeners {
+		if !seen[pattern] {
+			patterns = append(patterns, pattern)
+		}
+	}
+	slices.Sort(patterns)
+	return patterns
 }
 
-// handleMessage routes the message to the appropriate handler
-func (r *EventRouter) handleMessage(d amqp.Delivery) {
-	log.Printf("Received message with routing key: %s", d.RoutingKey)
+// resolve returns the handlers to run for a routing key: the handler of the most
+// specific matching pattern first, then the listeners ordered by pattern precedence
+func (r *EventRouter) resolve(routingKey string) []EventHandler {
+	var handlers []EventHandler
 
-	// Find the appropriate handler for this routing key
-	handler, exists := r.handlers[d.RoutingKey]
-	if !exists {
-		// Check if there's a wildcard handler that matches
-		for pattern, wildcardHandler := range r.handlers {
-			if matchesWildcard(pattern, d.RoutingKey) {
-				handler = wildcardHandler
-				exists = true
-				break
-			}
+	best := ""
+	for pattern := range r.handlers {
+		if matchesTopic(pattern, routingKey) && (best == "" || moreSpecific(pattern, best)) {
+			best = pattern
 		}
 	}
+	if best != "" {
+		handlers = append(handlers, r.handlers[best])
+	}
+
+	var matching []string
+	for pattern := range r.listeners {
+		if matchesTopic(pattern, routingKey) {
+			matching = append(matching, pattern)
+		}
+	}
+	slices.SortFunc(matching, func(a, b string) int {
+		if moreSpecific(a, b) {
+			return -1
+		}
+		return 1
+	})
+	for _, pattern := range matching {
+		handlers = append(handlers, r.listeners[pattern]...)
+	}
+	return handlers
+}
 
-	if !exists {
-		log.Printf("No handler registered for routing key: %s", d.RoutingKey)
+// Use adds middlewares wrapping every handler, the first one added being the outermost
+func (r *EventRouter) Use(middlewares ...Middleware) {
+	r.middlewares = append(r.middlewares, middlewares...)
+}
+
+// SetRetryPolicy overrides the default retry policy for a routing key
+func (r *EventRouter) SetRetryPolicy(routingKey string, policy RetryPolicy) {
+	r.policies[routingKey] = policy
+}
+
+// retryPolicy returns the retry policy that applies to a routing key
+func (r *EventRouter) retryPolicy(routingKey string) RetryPolicy {
+	if policy, ok := r.policies[routingKey]; ok {
+		return policy
+	}
+	return r.defaultPolicy
+}
+
+// allPolicies returns every retry policy in use, the default one included
+func (r *EventRouter) allPolicies() []RetryPolicy {
+	policies := []RetryPolicy{r.defaultPolicy}
+	for _, policy := range r.policies {
+		policies = append(policies, policy)
+	}
+	return policies
+}
+
+// QueueName returns the name of the queue the router currently consumes from
+func (r *EventRouter) QueueName() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.queue
+}
+
+// handleMessage routes the message to the appropriate handler.
+// Failed messages are redelivered later according to the retry policy of their routing key.
+func (r *EventRouter) handleMessage(ch *amqp.Channel, queue string, d amqp.Delivery) {
+	routingKey := originalRoutingKey(d)
+	log.Printf("Received message with routing key: %s", routingKey)
+
+	handlers := r.resolve(routingKey)
+	if len(handlers) == 0 {
+		log.Printf("No handler registered for routing key: %s", routingKey)
 		// Acknowledge the message to remove it from the queue
 		d.Ack(false)
 		return
 	}
 
-	// Process the message with the handler
-	err := handler(d.Body)
-	if err != nil {
-		log.Printf("Error processing message: %v", err)
-		// You might want to implement retries or dead letter queue here
-		// For now, we'll just acknowledge the message to remove it from the queue
-		d.Ack(false)
-		return
+	// Process the message with every handler. A failure redelivers the message to all
+	// of them, handlers are expected to skip messages they already processed.
+	msg := newEnvelope(d)
+	for _, handler := range handlers {
+		if err := chain(handler, r.middlewares)(context.Background(), msg); err != nil {
+			retryOrDeadLetter(ch, queue, d, routingKey, r.retryPolicy(routingKey), err)
+			return
+		}
 	}
 
 	// Successfully processed the message, acknowledge it
 	d.Ack(false)
 }
 
-// matchesWildcard checks if a routing key matches a pattern with wildcards
-func matchesWildcard(pattern, routingKey string) bool {
-	// Simple implementation: only supports * at the end
-	if pattern == "#" {
-		return true // # matches anything
+// newEnvelope builds the message passed to handlers from a delivery, accepting both
+// legacy JSON events and CloudEvents in binary or structured mode
+func newEnvelope(d amqp.Delivery) envelope.Envelope {
+	attempt := headerInt(d.Headers, HeaderAttempt, 1)
+	msg := envelope.Envelope{
+		RoutingKey:  originalRoutingKey(d),
+		MessageID:   messageID(d),
+		Headers:     d.Headers,
+		Timestamp:   d.Timestamp,
+		Redelivered: d.Redelivered || attempt > 1,
+		Attempt:     attempt,
+		Body:        d.Body,
 	}
 
-	if len(pattern) > 0 && pattern[len(pattern)-1] == '*' {
-		prefix := pattern[:len(pattern)-1]
-		return len(routingKey) >= len(prefix) && routingKey[:len(prefix)] == prefix
+	if ce, ok := decodeCloudEvent(d); ok {
+		if d.MessageId == "" && ce.ID != "" {
+			msg.MessageID = ce.ID
+		}
+		if !ce.Time.IsZero() {
+			msg.Timestamp = ce.Time
+		}
+		msg.Source = ce.Source
+		msg.Type = ce.Type
+		msg.DataVersion = ce.DataVersion
+		msg.CorrelationID = ce.CorrelationID
+		msg.Body = ce.Data
 	}
+	return msg
+}
 
-	return pattern == routingKey
+// messageID returns the AMQP message ID, or a hash of the routing key and body when
+// the publisher did not set one, so that redeliveries of a message share the same ID
+func messageID(d amqp.Delivery) string {
+	if d.MessageId != "" {
+		return d.MessageId
+	}
+	sum := sha256.Sum256(append([]byte(originalRoutingKey(d)+"\n"), d.Body...))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// QueueConfig describes the queue the service consumes events from
+type QueueConfig struct {
+	// Name of the queue, shared by all the instances so they compete for messages.
+	// An empty name declares an exclusive auto-delete queue private to this instance.
+	Name string
+	// Durable queues survive broker restarts and keep events published while the service is down
+	Durable bool
+	// Prefetch is the number of unacknowledged messages delivered to an instance at once
+	Prefetch int
+	// Workers is the number of messages processed in parallel. Messages about the same
+	// customer always go to the same worker and keep their order.
+	Workers int
+}
+
+// QueueConfigFromEnv reads EVENTS_QUEUE (default customers.events),
+// EVENTS_QUEUE_DURABLE (default true), EVENTS_PREFETCH (default 10) and EVENTS_WORKERS (default 4)
+func QueueConfigFromEnv() QueueConfig {
+	cfg := QueueConfig{
+		Name:     "customers.events",
+		Durable:  true,
+		Prefetch: 10,
+		Workers:  4,
+	}
+	if value, ok := os.LookupEnv("EVENTS_QUEUE"); ok {
+		cfg.Name = value
+	}
+	if value, err := strconv.ParseBool(os.Getenv("EVENTS_QUEUE_DURABLE")); err == nil {
+		cfg.Durable = value
+	}
+	if value, err := strconv.Atoi(os.Getenv("EVENTS_PREFETCH")); err == nil && value > 0 {
+		cfg.Prefetch = value
+	}
+	if value, err := strconv.Atoi(os.Getenv("EVENTS_WORKERS")); err == nil && value > 0 {
+		cfg.Workers = value
+	}
+	return cfg
 }
 
 // StartListening sets up a consumer to listen for RabbitMQ events
-func StartListening(ch *amqp.Channel, router *EventRouter) (string, error) {
-	// Declare a queue with a random name
+func StartListening(ch *amqp.Channel, router *EventRouter, cfg QueueConfig) (string, error) {
+	// Retried and dead-lettered copies are confirmed before the original is acked
+	if err := ch.Confirm(false); err != nil {
+		return "", err
+	}
+	if err := declareRetryTopology(ch, router.allPolicies()); err != nil {
+		return "", err
+	}
+
+	// Limit the messages in flight so several instances share the load
+	if cfg.Prefetch > 0 {
+		if err := ch.Qos(cfg.Prefetch, 0, false); err != nil {
+			return "", err
+		}
+	}
+
+	// A named queue is shared by every instance, an anonymous one is private to this instance
+	private := cfg.Name == ""
 	q, err := ch.QueueDeclare(
-		"",    // name (empty for auto-generated name)
-		false, // durable
-		true,  // delete when unused
-		true,  // exclusive
-		false, // no-wait
-		nil,   // arguments
+		cfg.Name,    // name (empty for auto-generated name)
+		cfg.Durable, // durable
+		private,     // delete when unused
+		private,     // exclusive
+		false,       // no-wait
+		nil,         // arguments
 	)
 	if err != nil {
 		return "", err
 	}
 
-	// Bind the queue to the exchange with routing keys
-	for routingKey := range router.handlers {
-		if routingKey == "#" {
-			// Special case: bind to all messages
-			err = ch.QueueBind(
-				q.Name,   // queue name
-				"#",      // routing key
-				"events", // exchange
-				false,    // no-wait
-				nil,      // arguments
-			)
-		} else if len(routingKey) > 0 && routingKey[len(routingKey)-1] == '*' {
-			// Wildcard binding
-			bindingKey := routingKey[:len(routingKey)-1] + "#"
-			err = ch.QueueBind(
-				q.Name,     // queue name
-				bindingKey, // routing key with AMQP wildcard
-				"events",   // exchange
-				false,      // no-wait
-				nil,        // arguments
-			)
-		} else {
-			// Standard binding
-			err = ch.QueueBind(
-				q.Name,     // queue name
-				routingKey, // routing key
-				"events",   // exchange
-				false,      // no-wait
-				nil,        // arguments
-			)
-		}
-
+	// Bind the queue to the exchange with the router patterns, which use the same
+	// topic syntax as the broker
+	for _, pattern := range router.patterns() {
+		err = ch.QueueBind(
+			q.Name,   // queue name
+			pattern,  // routing key pattern
+			"events", // exchange
+			false,    // no-wait
+			nil,      // arguments
+		)
 		if err != nil {
 			return "", err
 		}
@@ -145,14 +302,13 @@ func StartListening(ch *amqp.Channel, router *EventRouter) (string, error) {
 		return "", err
 	}
 
-	// Start a goroutine to process messages
-	go func() {
-		for d := range msgs {
-			router.handleMessage(d)
-		}
-		log.Println("RabbitMQ consumer channel closed")
-	}()
+	router.mu.Lock()
+	router.queue = q.Name
+	router.mu.Unlock()
+
+	// Process messages in a pool of workers
+	go router.consume(ch, q.Name, msgs, cfg.Workers, cfg.Prefetch)
 
-	log.Printf("Started listening for events on queue %s", q.Name)
+	log.Printf("Started listening for events on queue %s with %d workers", q.Name, cfg.Workers)
 	return q.Name, nil
 }
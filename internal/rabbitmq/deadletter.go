@@ -0,0 +1,162 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ErrDeadLetterNotFound is returned when no dead-lettered message has the requested ID
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// DeadLetter is a message parked in the dead-letter queue
+type DeadLetter struct {
+	ID          string `json:"id"`
+	MessageID   string `json:"message_id,omitempty"`
+	RoutingKey  string `json:"routing_key"`
+	Attempts    int    `json:"attempts"`
+	LastError   string `json:"last_error"`
+	FailedAt    string `json:"failed_at"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        string `json:"body"`
+}
+
+func newDeadLetter(d amqp.Delivery) DeadLetter {
+	id, _ := d.Headers[HeaderDeadLetterID].(string)
+	lastError, _ := d.Headers[HeaderError].(string)
+	failedAt, _ := d.Headers[HeaderFailedAt].(string)
+	return DeadLetter{
+		ID:          id,
+		MessageID:   d.MessageId,
+		RoutingKey:  originalRoutingKey(d),
+		Attempts:    headerInt(d.Headers, HeaderAttempt, 1),
+		LastError:   lastError,
+		FailedAt:    failedAt,
+		ContentType: d.ContentType,
+		Body:        string(d.Body),
+	}
+}
+
+// DeadLetters browses and re-drives the dead-letter queue.
+// Messages are read with basic.get on a short-lived channel: closing the channel
+// puts back every message that was not explicitly acked, so browsing is non-destructive.
+type DeadLetters struct {
+	conn   *ConnectionManager
+	router *EventRouter
+}
+
+// NewDeadLetters creates a dead-letter browser re-driving messages to the router's queue
+func NewDeadLetters(conn *ConnectionManager, router *EventRouter) *DeadLetters {
+	return &DeadLetters{conn: conn, router: router}
+}
+
+// List returns up to limit dead-lettered messages along with the queue depth
+func (q *DeadLetters) List(limit int) ([]DeadLetter, int, error) {
+	ch, err := q.conn.Channel()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer ch.Close()
+
+	queue, err := ch.QueueDeclarePassive(DeadLetterQueue, true, false, false, false, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	letters := []DeadLetter{}
+	for len(letters) < limit {
+		d, ok, err := ch.Get(DeadLetterQueue, false)
+		if err != nil {
+			return nil, 0, err
+		}
+		if !ok {
+			break
+		}
+		letters = append(letters, newDeadLetter(d))
+	}
+	return letters, queue.Messages, nil
+}
+
+// Get returns the dead-lettered message with the given ID
+func (q *DeadLetters) Get(id string) (*DeadLetter, error) {
+	ch, err := q.conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	defer ch.Close()
+
+	d, err := findDeadLetter(ch, id)
+	if err != nil {
+		return nil, err
+	}
+	letter := newDeadLetter(d)
+	return &letter, nil
+}
+
+// Redrive sends the dead-lettered message with the given ID back to the consumer queue
+// with a fresh attempt count, and removes it from the dead-letter queue
+func (q *DeadLetters) Redrive(ctx context.Context, id string) (*DeadLetter, error) {
+	queue := q.router.QueueName()
+	if queue == "" {
+		return nil, ErrNotConnected
+	}
+
+	ch, err := q.conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	defer ch.Close()
+
+	if err := ch.Confirm(false); err != nil {
+		return nil, err
+	}
+
+	d, err := findDeadLetter(ch, id)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	delete(headers, HeaderAttempt)
+	delete(headers, HeaderError)
+	delete(headers, HeaderFailedAt)
+	delete(headers, HeaderDeadLetterID)
+	headers[HeaderOriginalRoutingKey] = originalRoutingKey(d)
+
+	if err := publishCopy(ctx, ch, "", queue, d, headers); err != nil {
+		return nil, err
+	}
+	if err := d.Ack(false); err != nil {
+		return nil, err
+	}
+
+	letter := newDeadLetter(d)
+	return &letter, nil
+}
+
+// findDeadLetter reads the dead-letter queue until the message with the given ID shows up.
+// The other messages stay unacked and are put back when the channel is closed.
+func findDeadLetter(ch *amqp.Channel, id string) (amqp.Delivery, error) {
+	queue, err := ch.QueueDeclarePassive(DeadLetterQueue, true, false, false, false, nil)
+	if err != nil {
+		return amqp.Delivery{}, err
+	}
+
+	for range queue.Messages {
+		d, ok, err := ch.Get(DeadLetterQueue, false)
+		if err != nil {
+			return amqp.Delivery{}, err
+		}
+		if !ok {
+			break
+		}
+		if d.Headers[HeaderDeadLetterID] == id {
+			return d, nil
+		}
+	}
+	return amqp.Delivery{}, ErrDeadLetterNotFound
+}
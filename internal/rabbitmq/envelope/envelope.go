@@ -0,0 +1,54 @@
+// Package envelope holds the message type passed to event handlers, kept apart from
+// the rabbitmq package so that handlers do not depend on the consumer.
+package envelope
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Envelope is a consumed message along with its AMQP metadata
+type Envelope struct {
+	// RoutingKey is the key the message was first published with, even after a retry
+	RoutingKey string
+	// MessageID identifies the message across redeliveries
+	MessageID string
+	Headers   map[string]any
+	Timestamp time.Time
+	// Redelivered is set when the broker or the retry policy delivers the message again
+	Redelivered bool
+	// Attempt is the delivery attempt, starting at 1
+	Attempt int
+
+	// CloudEvents attributes, empty for legacy events
+	Source        string
+	Type          string
+	DataVersion   string
+	CorrelationID string
+
+	// Body is the event data, unwrapped from its CloudEvent in structured mode
+	Body []byte
+}
+
+// Decode unmarshals the JSON body into v
+func (e Envelope) Decode(v any) error {
+	if err := json.Unmarshal(e.Body, v); err != nil {
+		return fmt.Errorf("unmarshaling %s event: %w", e.RoutingKey, err)
+	}
+	return nil
+}
+
+type traceIDKey struct{}
+
+// WithTraceID returns a context carrying the trace ID of the message being handled
+func WithTraceID(ctx context.Context, traceID string) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, traceID)
+}
+
+// TraceID returns the trace ID of the message being handled, if any
+func TraceID(ctx context.Context) string {
+	traceID, _ := ctx.Value(traceIDKey{}).(string)
+	return traceID
+}
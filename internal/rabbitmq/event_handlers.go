@@ -1,32 +1,50 @@
 package rabbitmq
 
 import (
+	"time"
+
 	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/rabbitmq/event_handlers"
-	"gorm.io/gorm"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/repository"
 )
 
-// SetupEventHandlers configures handlers for different event types
-func SetupEventHandlers(dbConn *gorm.DB) *EventRouter {
+// handlerTimeout bounds the time a handler can spend on a single message
+const handlerTimeout = 30 * time.Second
+
+// handlerMiddlewares returns the middlewares wrapping every handler. Recover is the
+// innermost one so that a panic goes through the failure path of Logging and Metrics.
+func handlerMiddlewares() []Middleware {
+	return []Middleware{Tracing(), Logging(), Metrics(), Timeout(handlerTimeout), Recover()}
+}
+
+// SetupEventHandlers configures handlers for different event types, feeding the
+// projections of orders and products
+func SetupEventHandlers(projections repository.ProjectionRepository) *EventRouter {
 	router := NewEventRouter()
+	router.Use(handlerMiddlewares()...)
 
 	// Initialize event handlers
-	orderHandlers := event_handlers.NewOrderEventHandlers(dbConn)
-	productHandlers := event_handlers.NewProductEventHandlers(dbConn)
-	debugHandlers := event_handlers.NewDebugEventHandlers()
+	orderHandlers := event_handlers.NewOrderEventHandlers(projections)
+	productHandlers := event_handlers.NewProductEventHandlers(projections)
 
 	// Register order event handlers
 	router.RegisterHandler("order.created", orderHandlers.HandleOrderCreated)
 	router.RegisterHandler("order.updated", orderHandlers.HandleOrderUpdated)
 	router.RegisterHandler("order.deleted", orderHandlers.HandleOrderDeleted)
 
+	// Orders can reference a customer created a moment ago on another instance,
+	// give them more time before giving up
+	router.SetRetryPolicy("order.created", RetryPolicy{
+		MaxAttempts: 5,
+		Delays:      []time.Duration{5 * time.Second, 30 * time.Second, 2 * time.Minute},
+	})
+
 	// Register product event handlers
 	router.RegisterHandler("product.created", productHandlers.HandleProductCreated)
 	router.RegisterHandler("product.updated", productHandlers.HandleProductUpdated)
 	router.RegisterHandler("product.deleted", productHandlers.HandleProductDeleted)
 
-	// Register debug catch-all handler
-	// Useful during development, can be removed in production
-	router.RegisterHandler("#", debugHandlers.HandleAllEvents)
+	// No catch-all "#" handler: it would bind every routing key to the shared queue and
+	// make unroutable customer events look delivered to the outbox relay
 
 	return router
 }
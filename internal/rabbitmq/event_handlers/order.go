@@ -1,98 +1,111 @@
 package event_handlers
 
 import (
-	"encoding/json"
+	"context"
 	"log"
+	"time"
 
 	"github.com/PayeTonKawa-EPSI-2025/Common-V2/events"
 	localModels "github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/models"
-	"gorm.io/gorm"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/rabbitmq/envelope"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/repository"
 )
 
-// OrderEventHandlers provides handlers for order-related events
-type OrderEventHandlers struct {
-	db *gorm.DB
+// orderEvent is an events.OrderEvent along with the optional order details
+// the Orders service sends, which feed the local order projection
+type orderEvent struct {
+	Type      events.EventType `json:"type"`
+	Order     orderDetails     `json:"order"`
+	Timestamp time.Time        `json:"timestamp"`
 }
 
-// NewOrderEventHandlers creates a new order event handlers instance
-func NewOrderEventHandlers(db *gorm.DB) *OrderEventHandlers {
-	return &OrderEventHandlers{db: db}
+type orderDetails struct {
+	events.SimplifiedOrder
+	Status    string    `json:"status,omitempty"`
+	Total     float64   `json:"total,omitempty"`
+	CreatedAt time.Time `json:"createdAt,omitempty"`
 }
 
-// HandleOrderCreated handles the order.created event
-func (h *OrderEventHandlers) HandleOrderCreated(body []byte) error {
-	var event events.OrderEvent
-	if err := json.Unmarshal(body, &event); err != nil {
-		log.Printf("Error unmarshaling order.created event: %v", err)
-		return err
+// projection returns the local order described by the event.
+// The event time stands in for the order date when the event does not carry it.
+func (e orderEvent) projection() localModels.Order {
+	order := localModels.Order{
+		CustomerID: e.Order.CustomerID,
+		Status:     e.Order.Status,
+		Total:      e.Order.Total,
+		OrderedAt:  e.Order.CreatedAt,
+		ProductIDs: e.Order.ProductIDs,
 	}
-
-	log.Printf("Received order.created event for order %d", event.Order.OrderID)
-
-	// Create the order in the local database
-	order := localModels.Order{}
-	order.ID = event.Order.OrderID
-
-	if err := h.db.Create(&order).Error; err != nil {
-		log.Printf("Error creating order in DB: %v", err)
-		return err
+	order.ID = e.Order.OrderID
+	if order.OrderedAt.IsZero() {
+		order.OrderedAt = e.Timestamp
 	}
+	return order
+}
 
-	log.Printf("Successfully created order %d in local database", order.ID)
+// OrderEventHandlers provides handlers for order-related events
+type OrderEventHandlers struct {
+	projections repository.ProjectionRepository
+}
 
-	customerOrder := localModels.CustomerOrder{}
-	customerOrder.CustomerID = event.Order.CustomerID
-	customerOrder.OrderID = event.Order.OrderID
+// NewOrderEventHandlers creates a new order event handlers instance
+func NewOrderEventHandlers(projections repository.ProjectionRepository) *OrderEventHandlers {
+	return &OrderEventHandlers{projections: projections}
+}
 
-	if err := h.db.Create(&customerOrder).Error; err != nil {
-		log.Printf("Error creating customer order in DB: %v", err)
+// HandleOrderCreated handles the order.created event
+func (h *OrderEventHandlers) HandleOrderCreated(ctx context.Context, msg envelope.Envelope) error {
+	var event orderEvent
+	if err := msg.Decode(&event); err != nil {
 		return err
 	}
 
-	log.Printf("Successfully created customer order for customer %d and order %d in local database", event.Order.CustomerID, order.ID)
+	return h.projections.ProcessOnce(ctx, msg.MessageID, "order.created", func(ctx context.Context) error {
+		// Create the order in the local database
+		order := event.projection()
+		if err := h.projections.CreateOrder(ctx, order); err != nil {
+			return err
+		}
 
-	return nil
+		log.Printf("Created order %d for customer %d in local database", order.ID, event.Order.CustomerID)
+		return nil
+	})
 }
 
 // HandleOrderUpdated handles the order.updated event
-func (h *OrderEventHandlers) HandleOrderUpdated(body []byte) error {
-	var event events.OrderEvent
-	if err := json.Unmarshal(body, &event); err != nil {
-		log.Printf("Error unmarshaling order.updated event: %v", err)
-		return err
-	}
-
-	log.Printf("Received order.updated event for order %d", event.Order.OrderID)
-
-	// Update the order in the local database
-	order := localModels.Order{}
-	order.ID = event.Order.OrderID
-
-	if err := h.db.Save(&order).Error; err != nil {
-		log.Printf("Error updating order in DB: %v", err)
+func (h *OrderEventHandlers) HandleOrderUpdated(ctx context.Context, msg envelope.Envelope) error {
+	var event orderEvent
+	if err := msg.Decode(&event); err != nil {
 		return err
 	}
 
-	log.Printf("Successfully updated order %d in local database", order.ID)
-	return nil
+	return h.projections.ProcessOnce(ctx, msg.MessageID, "order.updated", func(ctx context.Context) error {
+		// Update the order in the local database, creating it if the creation was missed.
+		// Details missing from the event keep their current value.
+		order := event.projection()
+		columns := []string{"updated_at", "customer_id", "product_ids"}
+		if !event.Order.CreatedAt.IsZero() {
+			columns = append(columns, "ordered_at")
+		}
+		if event.Order.Status != "" {
+			columns = append(columns, "status")
+		}
+		if event.Order.Total != 0 {
+			columns = append(columns, "total")
+		}
+		return h.projections.UpsertOrder(ctx, order, columns)
+	})
 }
 
 // HandleOrderDeleted handles the order.deleted event
-func (h *OrderEventHandlers) HandleOrderDeleted(body []byte) error {
-	var event events.OrderEvent
-	if err := json.Unmarshal(body, &event); err != nil {
-		log.Printf("Error unmarshaling order.deleted event: %v", err)
-		return err
-	}
-
-	log.Printf("Received order.deleted event for order %d", event.Order.OrderID)
-
-	// Delete the order from the local database
-	if err := h.db.Delete(&localModels.Order{}, event.Order.OrderID).Error; err != nil {
-		log.Printf("Error deleting order from DB: %v", err)
+func (h *OrderEventHandlers) HandleOrderDeleted(ctx context.Context, msg envelope.Envelope) error {
+	var event orderEvent
+	if err := msg.Decode(&event); err != nil {
 		return err
 	}
 
-	log.Printf("Successfully deleted order %d from local database", event.Order.OrderID)
-	return nil
+	return h.projections.ProcessOnce(ctx, msg.MessageID, "order.deleted", func(ctx context.Context) error {
+		// Delete the order and its link to the customer from the local database
+		return h.projections.DeleteOrder(ctx, event.Order.OrderID)
+	})
 }
@@ -1,86 +1,58 @@
 package event_handlers
 
 import (
-	"encoding/json"
-	"log"
+	"context"
 
 	"github.com/PayeTonKawa-EPSI-2025/Common-V2/events"
-	localModels "github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/models"
-	"gorm.io/gorm"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/rabbitmq/envelope"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/repository"
 )
 
 // ProductEventHandlers provides handlers for product-related events
 type ProductEventHandlers struct {
-	db *gorm.DB
+	projections repository.ProjectionRepository
 }
 
 // NewProductEventHandlers creates a new product event handlers instance
-func NewProductEventHandlers(db *gorm.DB) *ProductEventHandlers {
-	return &ProductEventHandlers{db: db}
+func NewProductEventHandlers(projections repository.ProjectionRepository) *ProductEventHandlers {
+	return &ProductEventHandlers{projections: projections}
 }
 
 // HandleProductCreated handles the product.created event
-func (h *ProductEventHandlers) HandleProductCreated(body []byte) error {
+func (h *ProductEventHandlers) HandleProductCreated(ctx context.Context, msg envelope.Envelope) error {
 	var event events.ProductEvent
-	if err := json.Unmarshal(body, &event); err != nil {
-		log.Printf("Error unmarshaling product.created event: %v", err)
+	if err := msg.Decode(&event); err != nil {
 		return err
 	}
 
-	log.Printf("Received product.created event for product %d", event.Product.ID)
-
-	// Create the product in the local database
-	product := localModels.Product{}
-	product.ID = event.Product.ID
-
-	if err := h.db.Create(&product).Error; err != nil {
-		log.Printf("Error creating product in DB: %v", err)
-		return err
-	}
-
-	log.Printf("Successfully created product %d in local database", product.ID)
-	return nil
+	return h.projections.ProcessOnce(ctx, msg.MessageID, "product.created", func(ctx context.Context) error {
+		// Create the product in the local database
+		return h.projections.CreateProduct(ctx, event.Product.ID)
+	})
 }
 
 // HandleProductUpdated handles the product.updated event
-func (h *ProductEventHandlers) HandleProductUpdated(body []byte) error {
+func (h *ProductEventHandlers) HandleProductUpdated(ctx context.Context, msg envelope.Envelope) error {
 	var event events.ProductEvent
-	if err := json.Unmarshal(body, &event); err != nil {
-		log.Printf("Error unmarshaling product.updated event: %v", err)
-		return err
-	}
-
-	log.Printf("Received product.updated event for product %d", event.Product.ID)
-
-	// Update the product in the local database
-	product := localModels.Product{}
-	product.ID = event.Product.ID
-
-	if err := h.db.Save(&product).Error; err != nil {
-		log.Printf("Error updating product in DB: %v", err)
+	if err := msg.Decode(&event); err != nil {
 		return err
 	}
 
-	log.Printf("Successfully updated product %d in local database", product.ID)
-	return nil
+	return h.projections.ProcessOnce(ctx, msg.MessageID, "product.updated", func(ctx context.Context) error {
+		// Update the product in the local database
+		return h.projections.SaveProduct(ctx, event.Product.ID)
+	})
 }
 
 // HandleProductDeleted handles the product.deleted event
-func (h *ProductEventHandlers) HandleProductDeleted(body []byte) error {
+func (h *ProductEventHandlers) HandleProductDeleted(ctx context.Context, msg envelope.Envelope) error {
 	var event events.ProductEvent
-	if err := json.Unmarshal(body, &event); err != nil {
-		log.Printf("Error unmarshaling product.deleted event: %v", err)
-		return err
-	}
-
-	log.Printf("Received product.deleted event for product %d", event.Product.ID)
-
-	// Delete the product from the local database
-	if err := h.db.Delete(&localModels.Product{}, event.Product.ID).Error; err != nil {
-		log.Printf("Error deleting product from DB: %v", err)
+	if err := msg.Decode(&event); err != nil {
 		return err
 	}
 
-	log.Printf("Successfully deleted product %d from local database", event.Product.ID)
-	return nil
+	return h.projections.ProcessOnce(ctx, msg.MessageID, "product.deleted", func(ctx context.Context) error {
+		// Delete the product from the local database
+		return h.projections.DeleteProduct(ctx, event.Product.ID)
+	})
 }
@@ -3,55 +3,145 @@ package rabbitmq
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"time"
 
 	"github.com/PayeTonKawa-EPSI-2025/Common-V2/events"
-	"github.com/PayeTonKawa-EPSI-2025/Common-V2/models"
+	database "github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/db"
+	localModels "github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/models"
+	"github.com/go-chi/chi/v5/middleware"
 	amqp "github.com/rabbitmq/amqp091-go"
+	"gorm.io/gorm"
 )
 
-// PublishCustomerEvent publishes a customer event to RabbitMQ
-func PublishCustomerEvent(ch *amqp.Channel, eventType events.EventType, customer models.Customer) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	event := events.CustomerEvent{
-		Type:      eventType,
-		Customer:  customer,
-		Timestamp: time.Now(),
-	}
+// CustomerChangeEvent is a customer event carrying the list of fields touched by a partial update.
+// It serialises as a regular events.CustomerEvent plus a changedFields array, so existing
+// consumers keep working.
+type CustomerChangeEvent struct {
+	events.CustomerEvent
+	// ID identifies the event, it is also the ID of the message carrying it
+	ID            string   `json:"id,omitempty"`
+	ChangedFields []string `json:"changedFields,omitempty"`
+}
 
+// enqueue stores an event in the outbox, the request ID of the context of tx being its correlation ID
+func enqueue(tx *gorm.DB, event CustomerChangeEvent) error {
 	body, err := json.Marshal(event)
 	if err != nil {
 		log.Printf("Error marshaling event: %v", err)
 		return err
 	}
 
-	// Use a routing key based on the event type
-	routingKey := string(eventType)
+	return tx.Create(&localModels.OutboxEvent{
+		RoutingKey:    string(event.Type),
+		Payload:       body,
+		CorrelationID: middleware.GetReqID(tx.Statement.Context),
+		MessageID:     event.ID,
+	}).Error
+}
+
+// OutboxPublisher publishes customer events through the outbox: events are stored in the
+// transaction carried by the context of the call, and relayed to the broker once committed
+type OutboxPublisher struct {
+	db *gorm.DB
+}
+
+// NewOutboxPublisher creates a publisher storing events in db
+func NewOutboxPublisher(db *gorm.DB) *OutboxPublisher {
+	return &OutboxPublisher{db: db}
+}
+
+// PublishCustomerEvent stores the event in the outbox, within the transaction of ctx if any
+func (p *OutboxPublisher) PublishCustomerEvent(ctx context.Context, event CustomerChangeEvent) error {
+	return enqueue(database.Conn(ctx, p.db), event)
+}
+
+// NopPublisher drops customer events, for deployments running without RabbitMQ where
+// nothing would ever relay the outbox
+type NopPublisher struct{}
+
+// PublishCustomerEvent does nothing
+func (NopPublisher) PublishCustomerEvent(ctx context.Context, event CustomerChangeEvent) error {
+	return nil
+}
+
+// ErrUnroutable is returned when no queue is bound for the routing key of a published event
+var ErrUnroutable = errors.New("event not routed to any queue")
+
+// Publish publishes an event on the events exchange and waits for the broker to
+// confirm it. Events are published as mandatory: when no queue is bound for the routing
+// key the broker returns the message, and Publish fails with ErrUnroutable.
+func (m *ConnectionManager) Publish(ctx context.Context, routingKey string, msg amqp.Publishing) error {
+	m.mu.RLock()
+	ch, returns := m.publishCh, m.returns
+	m.mu.RUnlock()
+	if ch == nil {
+		publishFailures.WithLabelValues("disconnected").Inc()
+		return ErrNotConnected
+	}
+
+	m.publishMu.Lock()
+	defer m.publishMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
 
-	if ch != nil {
-		err = ch.PublishWithContext(
-			ctx,
-			"events", // exchange
-			routingKey,
-			false, // mandatory
-			false, // immediate
-			amqp.Publishing{
-				ContentType: "application/json",
-				Body:        body,
-			},
-		)
-	} else {
-		log.Println("RabbitMQ disabled: skipping event publish")
+	confirmation, err := ch.PublishWithDeferredConfirmWithContext(
+		ctx,
+		"events", // exchange
+		routingKey,
+		true,  // mandatory
+		false, // immediate
+		msg,
+	)
+	if err != nil {
+		publishFailures.WithLabelValues("error").Inc()
+		return err
 	}
 
+	acked, err := confirmation.WaitContext(ctx)
 	if err != nil {
-		log.Printf("Error publishing message: %v", err)
+		publishFailures.WithLabelValues("timeout").Inc()
 		return err
 	}
+	if !acked {
+		publishFailures.WithLabelValues("nack").Inc()
+		return errors.New("broker nacked the message")
+	}
 
-	log.Printf("Published %s event for customer %d", eventType, customer.ID)
+	if ret, ok := findReturn(returns, msg.MessageId); ok {
+		publishFailures.WithLabelValues("unroutable").Inc()
+		return fmt.Errorf("%w: %s (%d %s)", ErrUnroutable, routingKey, ret.ReplyCode, ret.ReplyText)
+	}
+
+	log.Printf("Published %s event %s", routingKey, msg.MessageId)
 	return nil
 }
+
+// findReturn drains the returned messages and reports whether messageID is among them.
+// The broker sends basic.return before the ack of an unroutable message, so any return
+// for a message is already buffered once its confirm has arrived.
+func findReturn(returns <-chan amqp.Return, messageID string) (amqp.Return, bool) {
+	for {
+		select {
+		case ret := <-returns:
+			if ret.MessageId == messageID {
+				return ret, true
+			}
+			log.Printf("Ignoring return of message %s: %s", ret.MessageId, ret.ReplyText)
+		default:
+			return amqp.Return{}, false
+		}
+	}
+}
+
+// outboxMessageID is the AMQP message ID of an outbox event, stable across retries: the
+// ID carried by its payload, or one derived from the row for events stored without it
+func outboxMessageID(event localModels.OutboxEvent) string {
+	if event.MessageID != "" {
+		return event.MessageID
+	}
+	return fmt.Sprintf("customers-outbox-%d", event.ID)
+}
@@ -0,0 +1,77 @@
+package rabbitmq
+
+import (
+	"context"
+	"database/sql/driver"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	localModels "github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/models"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestFindReturn(t *testing.T) {
+	returns := make(chan amqp.Return, 3)
+	returns <- amqp.Return{MessageId: "customers-outbox-1"}
+	returns <- amqp.Return{MessageId: "customers-outbox-2", ReplyCode: 312, ReplyText: "NO_ROUTE"}
+
+	ret, ok := findReturn(returns, "customers-outbox-2")
+	if !ok || ret.ReplyCode != 312 {
+		t.Fatalf("findReturn = %v, %v, want the return of customers-outbox-2", ret, ok)
+	}
+	if len(returns) != 0 {
+		t.Errorf("expected stale returns to be drained, %d left", len(returns))
+	}
+
+	if _, ok := findReturn(returns, "customers-outbox-3"); ok {
+		t.Error("expected no return for a routed message")
+	}
+}
+
+// captured is a sqlmock argument recording the value it is matched against
+type captured struct{ value *driver.Value }
+
+func (c captured) Match(v driver.Value) bool {
+	*c.value = v
+	return true
+}
+
+func TestOutboxPublisherPublishesEventID(t *testing.T) {
+	dbMock, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{Conn: dbMock}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open gorm DB: %v", err)
+	}
+
+	var payload, messageID driver.Value
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "outbox_events"`)).
+		WithArgs("customer.created", captured{&payload}, sqlmock.AnyArg(), nil, 0, "", nil, "", captured{&messageID}).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	event := CustomerChangeEvent{ID: "generated-1"}
+	event.Type = "customer.created"
+	if err := NewOutboxPublisher(gormDB).PublishCustomerEvent(context.Background(), event); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+
+	// The relay publishes the stored row under the generated ID
+	stored := localModels.OutboxEvent{ID: 1, RoutingKey: "customer.created", Payload: payload.([]byte), MessageID: messageID.(string)}
+	msg, err := newCustomerCloudEvent(stored).publishing(FormatBinary)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if msg.MessageId != "generated-1" || msg.Headers[cloudEventsHeaderPrefix+"id"] != "generated-1" {
+		t.Errorf("expected the generated ID to be published, got message ID %q and headers %v", msg.MessageId, msg.Headers)
+	}
+}
@@ -0,0 +1,48 @@
+package rabbitmq
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/repository"
+)
+
+// DefaultInboxRetention is how long processed messages are remembered by default
+const DefaultInboxRetention = 7 * 24 * time.Hour
+
+// InboxRetentionFromEnv reads the INBOX_RETENTION duration (e.g. "168h"), falling back to
+// DefaultInboxRetention. It must exceed the time a message can come back, retries and
+// dead-letter requeues included, or a redelivered message would be processed again.
+func InboxRetentionFromEnv() time.Duration {
+	if value := os.Getenv("INBOX_RETENTION"); value != "" {
+		retention, err := time.ParseDuration(value)
+		if err == nil && retention > 0 {
+			return retention
+		}
+		log.Printf("Invalid INBOX_RETENTION %q, using %s", value, DefaultInboxRetention)
+	}
+	return DefaultInboxRetention
+}
+
+// StartInboxPurge forgets the messages processed longer ago than retention every
+// interval until ctx is done
+func StartInboxPurge(ctx context.Context, projections repository.ProjectionRepository, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if n, err := projections.PurgeProcessed(ctx, retention); err != nil {
+					log.Printf("Error purging processed messages: %v", err)
+				} else if n > 0 {
+					log.Printf("Purged %d processed messages", n)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,54 @@
+package rabbitmq
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/repository"
+)
+
+// purgeRecorder is a ProjectionRepository recording the retention of each purge
+type purgeRecorder struct {
+	repository.ProjectionRepository
+	purges chan time.Duration
+}
+
+func (p *purgeRecorder) PurgeProcessed(ctx context.Context, retention time.Duration) (int64, error) {
+	p.purges <- retention
+	return 1, nil
+}
+
+func TestStartInboxPurge(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	projections := &purgeRecorder{purges: make(chan time.Duration, 1)}
+
+	StartInboxPurge(ctx, projections, 72*time.Hour, time.Millisecond)
+
+	select {
+	case retention := <-projections.purges:
+		if retention != 72*time.Hour {
+			t.Errorf("expected a 72h retention, got %s", retention)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the processed messages to be purged")
+	}
+}
+
+func TestInboxRetentionFromEnv(t *testing.T) {
+	t.Setenv("INBOX_RETENTION", "")
+	if got := InboxRetentionFromEnv(); got != DefaultInboxRetention {
+		t.Errorf("expected the default retention, got %s", got)
+	}
+
+	t.Setenv("INBOX_RETENTION", "720h")
+	if got := InboxRetentionFromEnv(); got != 720*time.Hour {
+		t.Errorf("expected 720h, got %s", got)
+	}
+
+	t.Setenv("INBOX_RETENTION", "soon")
+	if got := InboxRetentionFromEnv(); got != DefaultInboxRetention {
+		t.Errorf("expected an invalid retention to fall back to the default, got %s", got)
+	}
+}
@@ -0,0 +1,70 @@
+package rabbitmq
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var outboxBacklog = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "customer_events_outbox_backlog",
+	Help: "Number of customer events waiting in the outbox to be published",
+})
+
+var outboxFailed = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "customer_events_outbox_failed",
+	Help: "Number of customer events parked in the outbox after failing to be routed",
+})
+
+var rabbitConnected = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "rabbitmq_connected",
+	Help: "Whether the service is currently connected to RabbitMQ (1) or not (0)",
+})
+
+var rabbitReconnects = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "rabbitmq_reconnections_total",
+	Help: "Number of times the RabbitMQ connection was lost",
+})
+
+var eventsRetried = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "events_retried_total",
+	Help: "Number of consumed events scheduled for redelivery after a handler error",
+}, []string{"routing_key"})
+
+var eventsDeadLettered = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "events_dead_lettered_total",
+	Help: "Number of consumed events moved to the dead-letter queue",
+}, []string{"routing_key"})
+
+var eventsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "events_in_flight",
+	Help: "Number of consumed events received and not yet handled",
+})
+
+var eventsQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "events_queue_depth",
+	Help: "Number of events waiting in the consumer queue",
+})
+
+var eventsDeliveryLag = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Name:    "events_delivery_lag_seconds",
+	Help:    "Time between the publication of an event and its delivery to this service",
+	Buckets: []float64{0.01, 0.1, 0.5, 1, 5, 15, 60, 300, 900},
+})
+
+var eventsHandled = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "events_handled_total",
+	Help: "Number of consumed events handled, by routing key and result",
+}, []string{"routing_key", "result"})
+
+var eventsHandleDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "events_handle_duration_seconds",
+	Help:    "Time spent handling a consumed event",
+	Buckets: prometheus.DefBuckets,
+}, []string{"routing_key"})
+
+var publishFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "customer_events_publish_failures_total",
+	Help: "Number of customer events the broker did not accept, by reason (nack, unroutable, timeout, error, disconnected)",
+}, []string{"reason"})
+
+func init() {
+	prometheus.MustRegister(outboxBacklog, outboxFailed, rabbitConnected, rabbitReconnects, eventsRetried, eventsDeadLettered,
+		eventsInFlight, eventsQueueDepth, eventsDeliveryLag, eventsHandled, eventsHandleDuration, publishFailures)
+}
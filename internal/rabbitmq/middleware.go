@@ -0,0 +1,112 @@
+package rabbitmq
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/rabbitmq/envelope"
+)
+
+// Middleware wraps an event handler to add behaviour around it
+type Middleware func(next EventHandler) EventHandler
+
+// chain applies the middlewares to handler, the first one being the outermost
+func chain(handler EventHandler, middlewares []Middleware) EventHandler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// Recover turns a panicking handler into a failed one so the message goes through the retry policy
+func Recover() Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(ctx context.Context, msg envelope.Envelope) (err error) {
+			defer func() {
+				if p := recover(); p != nil {
+					log.Printf("Panic while handling %s message %s: %v\n%s", msg.RoutingKey, msg.MessageID, p, debug.Stack())
+					err = fmt.Errorf("handler panicked: %v", p)
+				}
+			}()
+			return next(ctx, msg)
+		}
+	}
+}
+
+// Logging logs every handled message with its outcome and duration
+func Logging() Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(ctx context.Context, msg envelope.Envelope) error {
+			start := time.Now()
+			err := next(ctx, msg)
+			if err != nil {
+				log.Printf("Error handling %s message %s (attempt %d, trace %s) after %s: %v",
+					msg.RoutingKey, msg.MessageID, msg.Attempt, envelope.TraceID(ctx), time.Since(start), err)
+				return err
+			}
+			log.Printf("Handled %s message %s (attempt %d, trace %s) in %s",
+				msg.RoutingKey, msg.MessageID, msg.Attempt, envelope.TraceID(ctx), time.Since(start))
+			return nil
+		}
+	}
+}
+
+// Metrics records the number and duration of handled messages per routing key and outcome
+func Metrics() Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(ctx context.Context, msg envelope.Envelope) error {
+			start := time.Now()
+			err := next(ctx, msg)
+			result := "success"
+			if err != nil {
+				result = "error"
+			}
+			eventsHandled.WithLabelValues(msg.RoutingKey, result).Inc()
+			eventsHandleDuration.WithLabelValues(msg.RoutingKey).Observe(time.Since(start).Seconds())
+			return err
+		}
+	}
+}
+
+// Tracing continues the trace of the W3C traceparent header, or starts a new one,
+// and exposes its ID to handlers through envelope.TraceID
+func Tracing() Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(ctx context.Context, msg envelope.Envelope) error {
+			traceID := traceIDFromParent(msg.Headers["traceparent"])
+			if traceID == "" {
+				b := make([]byte, 16)
+				_, _ = rand.Read(b)
+				traceID = hex.EncodeToString(b)
+			}
+			return next(envelope.WithTraceID(ctx, traceID), msg)
+		}
+	}
+}
+
+// traceIDFromParent extracts the trace ID of a traceparent header (version-traceid-parentid-flags)
+func traceIDFromParent(value any) string {
+	header, _ := value.(string)
+	parts := strings.Split(header, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 {
+		return ""
+	}
+	return parts[1]
+}
+
+// Timeout cancels the handler context when the handler takes longer than d
+func Timeout(d time.Duration) Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(ctx context.Context, msg envelope.Envelope) error {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+			return next(ctx, msg)
+		}
+	}
+}
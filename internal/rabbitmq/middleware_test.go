@@ -0,0 +1,86 @@
+package rabbitmq
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/rabbitmq/envelope"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestChainOrder(t *testing.T) {
+	var calls []string
+	named := func(name string) Middleware {
+		return func(next EventHandler) EventHandler {
+			return func(ctx context.Context, msg envelope.Envelope) error {
+				calls = append(calls, name)
+				return next(ctx, msg)
+			}
+		}
+	}
+
+	handler := chain(func(context.Context, envelope.Envelope) error {
+		calls = append(calls, "handler")
+		return nil
+	}, []Middleware{named("outer"), named("inner")})
+
+	if err := handler(context.Background(), envelope.Envelope{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"outer", "inner", "handler"}; !slices.Equal(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+}
+
+func TestRecoverTurnsPanicIntoError(t *testing.T) {
+	handler := Recover()(func(context.Context, envelope.Envelope) error {
+		panic("boom")
+	})
+
+	if err := handler(context.Background(), envelope.Envelope{RoutingKey: "order.created"}); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestHandlerMiddlewaresCountPanicsAsFailures(t *testing.T) {
+	handler := chain(func(context.Context, envelope.Envelope) error {
+		panic("boom")
+	}, handlerMiddlewares())
+
+	failures := eventsHandled.WithLabelValues("test.panicked", "error")
+	before := testutil.ToFloat64(failures)
+	if err := handler(context.Background(), envelope.Envelope{RoutingKey: "test.panicked"}); err == nil {
+		t.Fatal("expected an error")
+	}
+	if got := testutil.ToFloat64(failures) - before; got != 1 {
+		t.Errorf("expected the panic to be counted as a failure, counted %v", got)
+	}
+}
+
+func TestTracingContinuesTraceParent(t *testing.T) {
+	var traceID string
+	handler := Tracing()(func(ctx context.Context, msg envelope.Envelope) error {
+		traceID = envelope.TraceID(ctx)
+		return nil
+	})
+
+	msg := envelope.Envelope{Headers: map[string]any{
+		"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	}}
+	_ = handler(context.Background(), msg)
+	if traceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("trace ID = %q", traceID)
+	}
+}
+
+func TestTimeoutSetsDeadline(t *testing.T) {
+	handler := Timeout(time.Second)(func(ctx context.Context, msg envelope.Envelope) error {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("expected a deadline")
+		}
+		return nil
+	})
+	_ = handler(context.Background(), envelope.Envelope{})
+}
@@ -0,0 +1,261 @@
+package rabbitmq
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/PayeTonKawa-EPSI-2025/Common-V2/events"
+	localModels "github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/models"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"gorm.io/gorm"
+)
+
+// maxUnroutableAttempts is the number of times an event nobody listens to is published
+// before it is parked, giving consumers starting at the same time a chance to bind
+const maxUnroutableAttempts = 10
+
+// outboxLeaderLock names the advisory lock held by the replica relaying the outbox
+const outboxLeaderLock = "customers.outbox_relay"
+
+// CustomerSnapshot is the type of the events carrying the current state of a customer,
+// enqueued by replays. They are relayed after live events, see relayBatch.
+const CustomerSnapshot events.EventType = "customer.snapshot"
+
+// Conditions selecting the pending events of each lane. The routing key is inlined so
+// that the partial index of the live lane applies.
+const (
+	pendingLive      = "sent_at IS NULL AND failed_at IS NULL AND routing_key <> '" + string(CustomerSnapshot) + "'"
+	pendingSnapshots = "sent_at IS NULL AND failed_at IS NULL AND routing_key = '" + string(CustomerSnapshot) + "'"
+)
+
+// outboxBroker publishes the relayed events, ConnectionManager being the implementation
+type outboxBroker interface {
+	Connected() bool
+	Publish(ctx context.Context, routingKey string, msg amqp.Publishing) error
+}
+
+// OutboxRelay publishes the events stored in the outbox table and marks them as sent.
+// A single replica relays at a time, the leader holding the outbox advisory lock, so
+// that events go out in the order they were stored.
+type OutboxRelay struct {
+	db        *gorm.DB
+	conn      outboxBroker
+	interval  time.Duration
+	batchSize int
+	retention time.Duration
+	format    EventFormat
+	// leader holds the advisory lock while this replica is the leader
+	leader *sql.Conn
+}
+
+// NewOutboxRelay creates a relay publishing on the current channel of conn.
+// OUTBOX_POLL_INTERVAL (default 1s) and OUTBOX_BATCH_SIZE (default 100) tune the polling,
+// OUTBOX_RETENTION (default 168h) is how long sent events are kept for troubleshooting.
+// Events are published as CloudEvents in the format selected by EVENT_FORMAT.
+func NewOutboxRelay(db *gorm.DB, conn *ConnectionManager) *OutboxRelay {
+	relay := &OutboxRelay{
+		db:        db,
+		conn:      conn,
+		interval:  time.Second,
+		batchSize: 100,
+		retention: 7 * 24 * time.Hour,
+		format:    EventFormatFromEnv(),
+	}
+	if value, err := time.ParseDuration(os.Getenv("OUTBOX_POLL_INTERVAL")); err == nil && value > 0 {
+		relay.interval = value
+	}
+	if value, err := strconv.Atoi(os.Getenv("OUTBOX_BATCH_SIZE")); err == nil && value > 0 {
+		relay.batchSize = value
+	}
+	if value, err := time.ParseDuration(os.Getenv("OUTBOX_RETENTION")); err == nil && value > 0 {
+		relay.retention = value
+	}
+	return relay
+}
+
+// Start polls the outbox in a goroutine until ctx is done
+func (r *OutboxRelay) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			if !r.lead(ctx) {
+				select {
+				case <-ctx.Done():
+					log.Println("Outbox relay stopped")
+					return
+				case <-ticker.C:
+					continue
+				}
+			}
+
+			for {
+				sent, err := r.relayBatch(ctx)
+				if err != nil {
+					log.Printf("Error relaying outbox events: %v", err)
+				}
+				// Keep draining while full batches are going out
+				if err != nil || sent < r.batchSize {
+					break
+				}
+			}
+			r.purgeSent(ctx)
+			r.updateBacklog(ctx)
+
+			select {
+			case <-ctx.Done():
+				r.resign()
+				log.Println("Outbox relay stopped")
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// lead reports whether this replica is the relay leader, taking the lead when no other
+// replica holds it. Advisory locks belong to a session, the lock is held on a dedicated
+// connection for as long as it stays alive.
+func (r *OutboxRelay) lead(ctx context.Context) bool {
+	if r.leader != nil {
+		if err := r.leader.PingContext(ctx); err == nil {
+			return true
+		}
+		// The session is gone along with its lock, another replica may have taken over
+		log.Println("Lost the outbox relay lead")
+		_ = r.leader.Close()
+		r.leader = nil
+	}
+
+	sqlDB, err := r.db.DB()
+	if err != nil {
+		log.Printf("Error taking the outbox relay lead: %v", err)
+		return false
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		log.Printf("Error taking the outbox relay lead: %v", err)
+		return false
+	}
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock(hashtext($1))`, outboxLeaderLock).Scan(&acquired); err != nil || !acquired {
+		if err != nil {
+			log.Printf("Error taking the outbox relay lead: %v", err)
+		}
+		_ = conn.Close()
+		return false
+	}
+
+	log.Println("Took the outbox relay lead")
+	r.leader = conn
+	return true
+}
+
+// resign releases the lead so that another replica takes over without waiting for
+// this connection to be closed
+func (r *OutboxRelay) resign() {
+	if r.leader == nil {
+		return
+	}
+	if _, err := r.leader.ExecContext(context.Background(), `SELECT pg_advisory_unlock(hashtext($1))`, outboxLeaderLock); err != nil {
+		log.Printf("Error releasing the outbox relay lead: %v", err)
+	}
+	_ = r.leader.Close()
+	r.leader = nil
+}
+
+// relayBatch publishes the oldest pending events, in order, and returns how many were sent.
+// Live events go first and snapshots fill the rest of the batch, so that a replay
+// flooding the outbox, possibly with snapshots nobody listens to yet, does not delay
+// them. A snapshot can therefore reach consumers after a later change of its customer,
+// which they tell by its UpdatedAt.
+// Only the leader relays, so no row is locked and no transaction stays open while
+// publishing. Delivery is at least once: an event published by a leader that dies before
+// marking it sent is published again by the next one.
+func (r *OutboxRelay) relayBatch(ctx context.Context) (int, error) {
+	if !r.conn.Connected() {
+		// Disconnected, events stay in the outbox until the connection is back
+		return 0, nil
+	}
+
+	db := r.db.WithContext(ctx)
+	var pending []localModels.OutboxEvent
+	if err := db.Where(pendingLive).Order("id").Limit(r.batchSize).Find(&pending).Error; err != nil {
+		return 0, err
+	}
+	if len(pending) < r.batchSize {
+		var snapshots []localModels.OutboxEvent
+		if err := db.Where(pendingSnapshots).Order("id").Limit(r.batchSize - len(pending)).Find(&snapshots).Error; err != nil {
+			return 0, err
+		}
+		pending = append(pending, snapshots...)
+	}
+
+	sent := 0
+	for _, event := range pending {
+		msg, err := newCustomerCloudEvent(event).publishing(r.format)
+		if err == nil {
+			err = r.conn.Publish(ctx, event.RoutingKey, msg)
+		}
+		if err != nil {
+			log.Printf("Error publishing outbox event %d: %v", event.ID, err)
+			updates := map[string]any{
+				"attempts":   gorm.Expr("attempts + 1"),
+				"last_error": err.Error(),
+			}
+			// Nobody listens to this routing key, so later events cannot overtake it
+			// for any consumer: retry it on next tick without holding the others back,
+			// and park it once it failed often enough not to fill every batch
+			unroutable := errors.Is(err, ErrUnroutable)
+			if unroutable && event.Attempts+1 >= maxUnroutableAttempts {
+				log.Printf("Parking outbox event %d after %d unroutable attempts", event.ID, event.Attempts+1)
+				updates["failed_at"] = time.Now()
+			}
+			if err := db.Model(&event).Updates(updates).Error; err != nil {
+				return sent, err
+			}
+			if unroutable {
+				continue
+			}
+			// Stop at the first failure to keep events in order, retry on next tick
+			return sent, nil
+		}
+
+		if err := db.Model(&event).Update("sent_at", time.Now()).Error; err != nil {
+			return sent, err
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// purgeSent deletes the events sent longer ago than the retention period
+func (r *OutboxRelay) purgeSent(ctx context.Context) {
+	err := r.db.WithContext(ctx).
+		Where("sent_at < ?", time.Now().Add(-r.retention)).
+		Delete(&localModels.OutboxEvent{}).Error
+	if err != nil {
+		log.Printf("Error purging sent outbox events: %v", err)
+	}
+}
+
+// updateBacklog refreshes the outbox backlog and failed events gauges
+func (r *OutboxRelay) updateBacklog(ctx context.Context) {
+	var pending, failed int64
+	if err := r.db.WithContext(ctx).Model(&localModels.OutboxEvent{}).Where("sent_at IS NULL AND failed_at IS NULL").Count(&pending).Error; err != nil {
+		log.Printf("Error counting outbox backlog: %v", err)
+		return
+	}
+	if err := r.db.WithContext(ctx).Model(&localModels.OutboxEvent{}).Where("failed_at IS NOT NULL").Count(&failed).Error; err != nil {
+		log.Printf("Error counting failed outbox events: %v", err)
+		return
+	}
+	outboxBacklog.Set(float64(pending))
+	outboxFailed.Set(float64(failed))
+}
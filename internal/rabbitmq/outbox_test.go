@@ -0,0 +1,208 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// fakeBroker is an outboxBroker failing the publishes of the routing keys in errs
+type fakeBroker struct {
+	errs      map[string]error
+	published []string
+}
+
+func (b *fakeBroker) Connected() bool {
+	return true
+}
+
+func (b *fakeBroker) Publish(ctx context.Context, routingKey string, msg amqp.Publishing) error {
+	if err := b.errs[routingKey]; err != nil {
+		return err
+	}
+	b.published = append(b.published, msg.MessageId)
+	return nil
+}
+
+func setupMockRelay(t *testing.T) (*OutboxRelay, sqlmock.Sqlmock) {
+	dbMock, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{Conn: dbMock}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open gorm DB: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("unfulfilled sqlmock expectations: %v", err)
+		}
+	})
+	return &OutboxRelay{db: gormDB, batchSize: 100, format: FormatBinary}, mock
+}
+
+func expectLeaderLock(mock sqlmock.Sqlmock, acquired bool) {
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT pg_try_advisory_lock(hashtext($1))`)).
+		WithArgs(outboxLeaderLock).
+		WillReturnRows(sqlmock.NewRows([]string{"pg_try_advisory_lock"}).AddRow(acquired))
+}
+
+func TestOutboxRelayLead(t *testing.T) {
+	relay, mock := setupMockRelay(t)
+	ctx := context.Background()
+
+	// Another replica leads
+	expectLeaderLock(mock, false)
+	if relay.lead(ctx) {
+		t.Fatal("expected the lead to be held by another replica")
+	}
+
+	// It stepped down, the lock is taken once and kept afterwards
+	expectLeaderLock(mock, true)
+	if !relay.lead(ctx) || !relay.lead(ctx) {
+		t.Fatal("expected the relay to take and keep the lead")
+	}
+
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_unlock(hashtext($1))`)).
+		WithArgs(outboxLeaderLock).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	relay.resign()
+	if relay.leader != nil {
+		t.Error("expected the lead to be released")
+	}
+}
+
+// outboxRows returns outbox rows with the given routing key and number of attempts, one per id
+func outboxRows(routingKey string, attempts int, ids ...int) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"id", "routing_key", "payload", "attempts", "message_id"})
+	for _, id := range ids {
+		rows.AddRow(id, routingKey, []byte(`{}`), attempts, fmt.Sprintf("evt-%d", id))
+	}
+	return rows
+}
+
+// expectLanes expects the pending live events then, unless they fill the batch, the
+// pending snapshots to be read
+func expectLanes(mock sqlmock.Sqlmock, live, snapshots *sqlmock.Rows) {
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "outbox_events" WHERE ` + pendingLive + ` ORDER BY id LIMIT $1`)).
+		WillReturnRows(live)
+	if snapshots != nil {
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "outbox_events" WHERE ` + pendingSnapshots + ` ORDER BY id LIMIT $1`)).
+			WillReturnRows(snapshots)
+	}
+}
+
+// expectPending expects the pending events to be read, returning a live event per id
+// with the given number of attempts and no snapshot
+func expectPending(mock sqlmock.Sqlmock, routingKey string, attempts int, ids ...int) {
+	expectLanes(mock, outboxRows(routingKey, attempts, ids...), outboxRows(string(CustomerSnapshot), 0))
+}
+
+func TestRelayBatchParksUnroutableEvents(t *testing.T) {
+	relay, mock := setupMockRelay(t)
+	unroutable := fmt.Errorf("%w: customer.created", ErrUnroutable)
+	relay.conn = &fakeBroker{errs: map[string]error{"customer.created": unroutable}}
+
+	// Below the threshold the attempt is counted and the event stays pending
+	expectPending(mock, "customer.created", maxUnroutableAttempts-2, 1)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "outbox_events" SET "attempts"=attempts + 1,"last_error"=$1 WHERE "id" = $2`)).
+		WithArgs(unroutable.Error(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if sent, err := relay.relayBatch(context.Background()); err != nil || sent != 0 {
+		t.Fatalf("relayBatch = %d, %v, want nothing sent", sent, err)
+	}
+
+	// The last allowed attempt parks it
+	expectPending(mock, "customer.created", maxUnroutableAttempts-1, 1)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "outbox_events" SET "attempts"=attempts + 1,"failed_at"=$1,"last_error"=$2 WHERE "id" = $3`)).
+		WithArgs(sqlmock.AnyArg(), unroutable.Error(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if sent, err := relay.relayBatch(context.Background()); err != nil || sent != 0 {
+		t.Fatalf("relayBatch = %d, %v, want nothing sent", sent, err)
+	}
+}
+
+func TestRelayBatchSkipsPastUnroutableEvents(t *testing.T) {
+	relay, mock := setupMockRelay(t)
+	broker := &fakeBroker{errs: map[string]error{"customer.created": ErrUnroutable}}
+	relay.conn = broker
+
+	live := sqlmock.NewRows([]string{"id", "routing_key", "payload", "attempts", "message_id"}).
+		AddRow(1, "customer.created", []byte(`{}`), 0, "evt-1").
+		AddRow(2, "customer.updated", []byte(`{}`), 0, "evt-2")
+	expectLanes(mock, live, outboxRows(string(CustomerSnapshot), 0))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "outbox_events" SET "attempts"=attempts + 1`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "outbox_events" SET "sent_at"=$1 WHERE "id" = $2`)).
+		WithArgs(sqlmock.AnyArg(), 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	sent, err := relay.relayBatch(context.Background())
+	if err != nil || sent != 1 {
+		t.Fatalf("relayBatch = %d, %v, want 1 sent", sent, err)
+	}
+	if len(broker.published) != 1 || broker.published[0] != "evt-2" {
+		t.Errorf("expected evt-2 to be published past the unroutable event, got %v", broker.published)
+	}
+}
+
+func TestRelayBatchRelaysLiveEventsBeforeSnapshots(t *testing.T) {
+	relay, mock := setupMockRelay(t)
+	relay.batchSize = 3
+	broker := &fakeBroker{errs: map[string]error{string(CustomerSnapshot): ErrUnroutable}}
+	relay.conn = broker
+
+	// Snapshots 1 to 100 were queued by a replay before live event 101, they only fill
+	// what the live events leave of the batch
+	expectLanes(mock, outboxRows("customer.updated", 0, 101), outboxRows(string(CustomerSnapshot), 0, 1, 2))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "outbox_events" SET "sent_at"=$1 WHERE "id" = $2`)).
+		WithArgs(sqlmock.AnyArg(), 101).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	for _, id := range []int{1, 2} {
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "outbox_events" SET "attempts"=attempts + 1,"last_error"=$1 WHERE "id" = $2`)).
+			WithArgs(sqlmock.AnyArg(), id).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+
+	sent, err := relay.relayBatch(context.Background())
+	if err != nil || sent != 1 {
+		t.Fatalf("relayBatch = %d, %v, want 1 sent", sent, err)
+	}
+	if len(broker.published) != 1 || broker.published[0] != "evt-101" {
+		t.Errorf("expected the live event to be published, got %v", broker.published)
+	}
+
+	// A full batch of live events leaves the snapshots for later
+	relay.batchSize = 1
+	expectLanes(mock, outboxRows("customer.updated", 0, 102), nil)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "outbox_events" SET "sent_at"=$1 WHERE "id" = $2`)).
+		WithArgs(sqlmock.AnyArg(), 102).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if sent, err := relay.relayBatch(context.Background()); err != nil || sent != 1 {
+		t.Fatalf("relayBatch = %d, %v, want 1 sent", sent, err)
+	}
+}
@@ -0,0 +1,215 @@
+package rabbitmq
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	// DeadLetterQueue holds the messages whose handler kept failing
+	DeadLetterQueue = "customers.dlq"
+
+	// Headers carried by retried and dead-lettered messages
+	HeaderAttempt            = "x-attempt"
+	HeaderOriginalRoutingKey = "x-original-routing-key"
+	HeaderError              = "x-last-error"
+	HeaderFailedAt           = "x-failed-at"
+	HeaderDeadLetterID       = "x-dead-letter-id"
+)
+
+// RetryPolicy tells how often a failing message is redelivered before being dead-lettered
+type RetryPolicy struct {
+	// MaxAttempts is the total number of deliveries, the first one included
+	MaxAttempts int
+	// Delays before each redelivery. The last delay is reused when there are more retries than delays.
+	Delays []time.Duration
+}
+
+// delay returns the wait before the given retry (1 for the first retry)
+func (p RetryPolicy) delay(retry int) time.Duration {
+	if len(p.Delays) == 0 {
+		return time.Second
+	}
+	return p.Delays[min(retry, len(p.Delays))-1]
+}
+
+// DefaultRetryPolicy reads EVENT_MAX_ATTEMPTS (default 3) and EVENT_RETRY_DELAYS
+// (comma separated durations, default "5s,30s")
+func DefaultRetryPolicy() RetryPolicy {
+	policy := RetryPolicy{
+		MaxAttempts: 3,
+		Delays:      []time.Duration{5 * time.Second, 30 * time.Second},
+	}
+	if value, err := strconv.Atoi(os.Getenv("EVENT_MAX_ATTEMPTS")); err == nil && value > 0 {
+		policy.MaxAttempts = value
+	}
+	if value := os.Getenv("EVENT_RETRY_DELAYS"); value != "" {
+		var delays []time.Duration
+		for _, part := range strings.Split(value, ",") {
+			delay, err := time.ParseDuration(strings.TrimSpace(part))
+			if err != nil || delay <= 0 {
+				log.Printf("Invalid EVENT_RETRY_DELAYS %q, using defaults", value)
+				return policy
+			}
+			delays = append(delays, delay)
+		}
+		policy.Delays = delays
+	}
+	return policy
+}
+
+// retryExchange is the fanout exchange, and the queue bound to it, that holds messages
+// for delay before dead-lettering them back to the consumer queue
+func retryExchange(delay time.Duration) string {
+	return "customers.retry." + delay.String()
+}
+
+// declareRetryTopology declares one wait queue per delay used by the policies and the
+// dead-letter queue. A message published to a wait exchange with the consumer queue name
+// as routing key expires after the delay and is routed back through the default exchange.
+func declareRetryTopology(ch *amqp.Channel, policies []RetryPolicy) error {
+	declared := map[time.Duration]bool{}
+	for _, policy := range policies {
+		for retry := 1; retry < policy.MaxAttempts; retry++ {
+			delay := policy.delay(retry)
+			if declared[delay] {
+				continue
+			}
+			declared[delay] = true
+
+			name := retryExchange(delay)
+			if err := ch.ExchangeDeclare(name, "fanout", true, false, false, false, nil); err != nil {
+				return err
+			}
+			if _, err := ch.QueueDeclare(name, true, false, false, false, waitQueueArgs(delay)); err != nil {
+				return err
+			}
+			if err := ch.QueueBind(name, "", name, false, nil); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err := ch.QueueDeclare(DeadLetterQueue, true, false, false, false, nil)
+	return err
+}
+
+// waitQueueArgs makes a wait queue expire its messages after delay and dead-letter them
+// through the default exchange, to the queue named by their routing key
+func waitQueueArgs(delay time.Duration) amqp.Table {
+	return amqp.Table{
+		"x-message-ttl":          delay.Milliseconds(),
+		"x-dead-letter-exchange": "",
+	}
+}
+
+// retryOrDeadLetter schedules a failed delivery for redelivery, or moves it to the
+// dead-letter queue once the policy is exhausted. The original delivery is acked only
+// when the copy has been confirmed by the broker, otherwise it is requeued.
+func retryOrDeadLetter(ch *amqp.Channel, queue string, d amqp.Delivery, routingKey string, policy RetryPolicy, cause error) {
+	exchange, key, headers := nextHop(queue, d, routingKey, policy, cause)
+	err := publishCopy(context.Background(), ch, exchange, key, d, headers)
+	if err != nil {
+		log.Printf("Error scheduling retry of %s message, requeueing it: %v", routingKey, err)
+		_ = d.Nack(false, true)
+		return
+	}
+	_ = d.Ack(false)
+}
+
+// nextHop returns where the copy of a failed delivery goes and its headers: the wait
+// exchange of the next retry, with queue as routing key, or the dead-letter queue once
+// the delivery made policy.MaxAttempts attempts
+func nextHop(queue string, d amqp.Delivery, routingKey string, policy RetryPolicy, cause error) (exchange, key string, headers amqp.Table) {
+	attempt := headerInt(d.Headers, HeaderAttempt, 1)
+
+	headers = amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[HeaderOriginalRoutingKey] = routingKey
+	headers[HeaderError] = cause.Error()
+
+	if attempt < policy.MaxAttempts {
+		delay := policy.delay(attempt)
+		headers[HeaderAttempt] = int32(attempt + 1)
+		exchange, key = retryExchange(delay), queue
+		log.Printf("Retrying %s message in %s (attempt %d/%d): %v", routingKey, delay, attempt+1, policy.MaxAttempts, cause)
+		eventsRetried.WithLabelValues(routingKey).Inc()
+	} else {
+		headers[HeaderFailedAt] = time.Now().UTC().Format(time.RFC3339)
+		headers[HeaderDeadLetterID] = newDeadLetterID()
+		exchange, key = "", DeadLetterQueue
+		log.Printf("Dead-lettering %s message after %d attempts: %v", routingKey, attempt, cause)
+		eventsDeadLettered.WithLabelValues(routingKey).Inc()
+	}
+	return exchange, key, headers
+}
+
+// publishCopy republishes a delivery with new headers and waits for the broker confirm
+func publishCopy(ctx context.Context, ch *amqp.Channel, exchange, key string, d amqp.Delivery, headers amqp.Table) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	confirmation, err := ch.PublishWithDeferredConfirmWithContext(ctx, exchange, key, false, false, amqp.Publishing{
+		Headers:      headers,
+		ContentType:  d.ContentType,
+		DeliveryMode: amqp.Persistent,
+		MessageId:    d.MessageId,
+		Timestamp:    d.Timestamp,
+		Body:         d.Body,
+	})
+	if err != nil {
+		return err
+	}
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		return fmt.Errorf("broker nacked the message")
+	}
+	return nil
+}
+
+// originalRoutingKey returns the routing key a message was first published with.
+// Retried messages come back through the default exchange with the queue name as key.
+func originalRoutingKey(d amqp.Delivery) string {
+	if key, ok := d.Headers[HeaderOriginalRoutingKey].(string); ok && key != "" {
+		return key
+	}
+	return d.RoutingKey
+}
+
+// headerInt reads an integer header whatever its AMQP integer type
+func headerInt(headers amqp.Table, name string, fallback int) int {
+	switch v := headers[name].(type) {
+	case int:
+		return v
+	case int8:
+		return int(v)
+	case int16:
+		return int(v)
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	default:
+		return fallback
+	}
+}
+
+func newDeadLetterID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
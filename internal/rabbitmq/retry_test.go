@@ -0,0 +1,124 @@
+package rabbitmq
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 5, Delays: []time.Duration{5 * time.Second, 30 * time.Second}}
+	tests := []struct {
+		name   string
+		policy RetryPolicy
+		retry  int
+		want   time.Duration
+	}{
+		{"first retry", policy, 1, 5 * time.Second},
+		{"second retry", policy, 2, 30 * time.Second},
+		{"last delay is reused", policy, 4, 30 * time.Second},
+		{"no delays", RetryPolicy{MaxAttempts: 3}, 2, time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.delay(tt.retry); got != tt.want {
+				t.Errorf("delay(%d) = %s, want %s", tt.retry, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextHop(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, Delays: []time.Duration{5 * time.Second, 30 * time.Second}}
+	cause := errors.New("customer not found")
+	tests := []struct {
+		name         string
+		headers      amqp.Table
+		wantExchange string
+		wantKey      string
+		wantAttempt  any
+	}{
+		{"first failure", nil, "customers.retry.5s", "customers.events", int32(2)},
+		{"second failure", amqp.Table{HeaderAttempt: int32(2)}, "customers.retry.30s", "customers.events", int32(3)},
+		{"attempts exhausted", amqp.Table{HeaderAttempt: int32(3)}, "", DeadLetterQueue, int32(3)},
+		{"attempt from another integer type", amqp.Table{HeaderAttempt: int64(3)}, "", DeadLetterQueue, int64(3)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := amqp.Delivery{Headers: tt.headers, RoutingKey: "customers.events"}
+			exchange, key, headers := nextHop("customers.events", d, "order.created", policy, cause)
+
+			if exchange != tt.wantExchange || key != tt.wantKey {
+				t.Errorf("next hop = %q %q, want %q %q", exchange, key, tt.wantExchange, tt.wantKey)
+			}
+			if headers[HeaderAttempt] != tt.wantAttempt {
+				t.Errorf("attempt header = %v, want %v", headers[HeaderAttempt], tt.wantAttempt)
+			}
+			if headers[HeaderOriginalRoutingKey] != "order.created" || headers[HeaderError] != cause.Error() {
+				t.Errorf("expected the routing key and error to be recorded, got %v", headers)
+			}
+			deadLettered := key == DeadLetterQueue
+			if _, ok := headers[HeaderDeadLetterID]; ok != deadLettered {
+				t.Errorf("expected a dead letter ID only when dead-lettering, got %v", headers)
+			}
+			if _, ok := headers[HeaderFailedAt]; ok != deadLettered {
+				t.Errorf("expected a failure time only when dead-lettering, got %v", headers)
+			}
+		})
+	}
+}
+
+func TestNextHopKeepsDeliveryHeaders(t *testing.T) {
+	d := amqp.Delivery{Headers: amqp.Table{"cloudEvents:id": "evt-1"}}
+	_, _, headers := nextHop("customers.events", d, "order.created", RetryPolicy{MaxAttempts: 2}, errors.New("boom"))
+
+	if headers["cloudEvents:id"] != "evt-1" {
+		t.Errorf("expected the CloudEvents headers to be kept, got %v", headers)
+	}
+	if _, ok := d.Headers[HeaderAttempt]; ok {
+		t.Error("expected the headers of the delivery to be left untouched")
+	}
+}
+
+func TestWaitQueue(t *testing.T) {
+	if name := retryExchange(90 * time.Second); name != "customers.retry.1m30s" {
+		t.Errorf("retryExchange = %q, want customers.retry.1m30s", name)
+	}
+
+	args := waitQueueArgs(30 * time.Second)
+	if args["x-message-ttl"] != int64(30000) || args["x-dead-letter-exchange"] != "" {
+		t.Errorf("unexpected wait queue arguments: %v", args)
+	}
+}
+
+func TestHeaderInt(t *testing.T) {
+	tests := []struct {
+		value any
+		want  int
+	}{
+		{int8(2), 2},
+		{int16(3), 3},
+		{int32(4), 4},
+		{int64(5), 5},
+		{6, 6},
+		{"7", 1},
+		{nil, 1},
+	}
+	for _, tt := range tests {
+		if got := headerInt(amqp.Table{HeaderAttempt: tt.value}, HeaderAttempt, 1); got != tt.want {
+			t.Errorf("headerInt(%#v) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestOriginalRoutingKey(t *testing.T) {
+	retried := amqp.Delivery{RoutingKey: "customers.events", Headers: amqp.Table{HeaderOriginalRoutingKey: "order.created"}}
+	if key := originalRoutingKey(retried); key != "order.created" {
+		t.Errorf("expected the original routing key of a retried message, got %q", key)
+	}
+	if key := originalRoutingKey(amqp.Delivery{RoutingKey: "order.created"}); key != "order.created" {
+		t.Errorf("expected the routing key of a first delivery, got %q", key)
+	}
+}
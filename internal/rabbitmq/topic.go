@@ -0,0 +1,67 @@
+package rabbitmq
+
+import "strings"
+
+// matchesTopic reports whether a routing key matches an AMQP topic pattern,
+// where * matches exactly one word and # matches zero or more words
+func matchesTopic(pattern, routingKey string) bool {
+	return matchWords(strings.Split(pattern, "."), strings.Split(routingKey, "."))
+}
+
+func matchWords(pattern, key []string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case "#":
+			// Collapse consecutive #, then try every possible length for this one
+			rest := pattern[1:]
+			if len(rest) == 0 {
+				return true
+			}
+			for i := 0; i <= len(key); i++ {
+				if matchWords(rest, key[i:]) {
+					return true
+				}
+			}
+			return false
+		case "*":
+			if len(key) == 0 {
+				return false
+			}
+		default:
+			if len(key) == 0 || key[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, key = pattern[1:], key[1:]
+	}
+	return len(key) == 0
+}
+
+// wordWeight ranks pattern words from the most to the least specific
+func wordWeight(word string) int {
+	switch word {
+	case "#":
+		return 0
+	case "*":
+		return 1
+	default:
+		return 2
+	}
+}
+
+// moreSpecific reports whether pattern a takes precedence over pattern b.
+// Patterns are compared word by word: a literal beats *, which beats #. When one pattern
+// is a prefix of the other the longer one wins, and remaining ties are broken alphabetically
+// so that the precedence never depends on registration or map order.
+func moreSpecific(a, b string) bool {
+	wa, wb := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(wa) && i < len(wb); i++ {
+		if x, y := wordWeight(wa[i]), wordWeight(wb[i]); x != y {
+			return x > y
+		}
+	}
+	if len(wa) != len(wb) {
+		return len(wa) > len(wb)
+	}
+	return a < b
+}
@@ -0,0 +1,116 @@
+package rabbitmq
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/rabbitmq/envelope"
+)
+
+func TestMatchesTopic(t *testing.T) {
+	tests := []struct {
+		pattern    string
+		routingKey string
+		want       bool
+	}{
+		{"order.created", "order.created", true},
+		{"order.created", "order.updated", false},
+		{"order.*", "order.created", true},
+		{"order.*", "order", false},
+		{"order.*", "order.created.eu", false},
+		{"order.*.eu", "order.created.eu", true},
+		{"order.*.eu", "order.created.us", false},
+		{"order.#", "order", true},
+		{"order.#", "order.created", true},
+		{"order.#", "order.created.eu", true},
+		{"order.#", "product.created", false},
+		{"#.deleted", "order.deleted", true},
+		{"#.deleted", "deleted", true},
+		{"#.deleted", "order.item.deleted", true},
+		{"#.deleted", "order.deleted.eu", false},
+		{"order.#.eu", "order.eu", true},
+		{"order.#.eu", "order.created.item.eu", true},
+		{"*.#", "order", true},
+		{"*.*", "order", false},
+		{"#.#", "order.created", true},
+		{"#", "order.created", true},
+		{"#", "", true},
+		{"*", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.routingKey, func(t *testing.T) {
+			if got := matchesTopic(tt.pattern, tt.routingKey); got != tt.want {
+				t.Errorf("matchesTopic(%q, %q) = %v, want %v", tt.pattern, tt.routingKey, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolvePrecedence(t *testing.T) {
+	tests := []struct {
+		name       string
+		patterns   []string
+		routingKey string
+		want       string
+	}{
+		{"exact beats wildcard", []string{"order.*", "order.created", "#"}, "order.created", "order.created"},
+		{"star beats hash", []string{"order.#", "order.*"}, "order.created", "order.*"},
+		{"earlier literal wins", []string{"*.created", "order.*"}, "order.created", "order.*"},
+		{"longer pattern wins", []string{"order.#", "order.#.eu"}, "order.created.eu", "order.#.eu"},
+		{"catch-all as fallback", []string{"order.*", "#"}, "product.created", "#"},
+		{"no match", []string{"order.*"}, "product.created", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var called []string
+			router := NewEventRouter()
+			for _, pattern := range tt.patterns {
+				router.RegisterHandler(pattern, func(context.Context, envelope.Envelope) error {
+					called = append(called, pattern)
+					return nil
+				})
+			}
+
+			for _, handler := range router.resolve(tt.routingKey) {
+				_ = handler(context.Background(), envelope.Envelope{})
+			}
+
+			var got string
+			if len(called) > 0 {
+				got = called[0]
+			}
+			if len(called) > 1 || got != tt.want {
+				t.Errorf("resolve(%q) called %v, want [%s]", tt.routingKey, called, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveFansOutToListeners(t *testing.T) {
+	var called []string
+	record := func(name string) EventHandler {
+		return func(context.Context, envelope.Envelope) error {
+			called = append(called, name)
+			return nil
+		}
+	}
+
+	router := NewEventRouter()
+	router.RegisterHandler("order.*", record("handler"))
+	router.AddListener("#", record("audit"))
+	router.AddListener("order.created", record("projection"))
+	router.AddListener("order.created", record("notifier"))
+	router.AddListener("product.*", record("unrelated"))
+
+	for _, handler := range router.resolve("order.created") {
+		_ = handler(context.Background(), envelope.Envelope{})
+	}
+
+	want := []string{"handler", "projection", "notifier", "audit"}
+	if !slices.Equal(called, want) {
+		t.Errorf("resolve called %v, want %v", called, want)
+	}
+}
@@ -0,0 +1,138 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// queueDepthInterval is how often the depth of the consumer queue is sampled
+const queueDepthInterval = 15 * time.Second
+
+// partitionKey returns the key deciding which worker handles a message.
+// Events about the same customer share a key so they are processed in order;
+// other events are keyed by message and spread over all workers.
+func partitionKey(d amqp.Delivery) string {
+	var event struct {
+		Order *struct {
+			CustomerID uint `json:"customerId"`
+		} `json:"order"`
+		Customer *struct {
+			ID uint `json:"ID"`
+		} `json:"customer"`
+	}
+	body := d.Body
+	if ce, ok := decodeCloudEvent(d); ok {
+		body = ce.Data
+	}
+	if err := json.Unmarshal(body, &event); err == nil {
+		if event.Order != nil && event.Order.CustomerID != 0 {
+			return "customer:" + strconv.FormatUint(uint64(event.Order.CustomerID), 10)
+		}
+		if event.Customer != nil && event.Customer.ID != 0 {
+			return "customer:" + strconv.FormatUint(uint64(event.Customer.ID), 10)
+		}
+	}
+	return messageID(d)
+}
+
+// partition maps a key to one of n workers
+func partition(key string, n int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
+
+// consume dispatches deliveries to a pool of workers until msgs is closed.
+// Each worker owns a partition of the keys and handles its deliveries one at a time,
+// so the channel prefetch bounds the number of messages in flight. Partitions buffer
+// up to prefetch deliveries, so a busy worker never holds back the others.
+func (r *EventRouter) consume(ch *amqp.Channel, queue string, msgs <-chan amqp.Delivery, workers, prefetch int) {
+	if workers < 1 {
+		workers = 1
+	}
+	if prefetch < 1 {
+		prefetch = 1
+	}
+
+	var wg sync.WaitGroup
+	partitions := make([]chan amqp.Delivery, workers)
+	for i := range partitions {
+		partitions[i] = make(chan amqp.Delivery, prefetch)
+		wg.Add(1)
+		go func(deliveries <-chan amqp.Delivery) {
+			defer wg.Done()
+			for d := range deliveries {
+				r.handleMessage(ch, queue, d)
+				eventsInFlight.Dec()
+			}
+		}(partitions[i])
+	}
+
+	for d := range msgs {
+		eventsInFlight.Inc()
+		if !d.Timestamp.IsZero() {
+			eventsDeliveryLag.Observe(time.Since(d.Timestamp).Seconds())
+		}
+		partitions[partition(partitionKey(d), workers)] <- d
+	}
+
+	for _, deliveries := range partitions {
+		close(deliveries)
+	}
+	wg.Wait()
+	log.Println("RabbitMQ consumer channel closed")
+}
+
+// SampleQueueDepth reports the number of messages waiting in the queue of the router
+// until ctx is done. Queues are inspected on a short-lived channel of their own, since
+// a failed passive declare closes the channel it runs on.
+func SampleQueueDepth(ctx context.Context, conn *ConnectionManager, router *EventRouter) {
+	go func() {
+		ticker := time.NewTicker(queueDepthInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				queue := router.QueueName()
+				if queue == "" {
+					continue
+				}
+				depth, err := queueDepth(conn, queue)
+				if errors.Is(err, ErrNotConnected) {
+					continue
+				}
+				if err != nil {
+					log.Printf("Error reading depth of queue %s: %v", queue, err)
+					continue
+				}
+				eventsQueueDepth.Set(float64(depth))
+			}
+		}
+	}()
+}
+
+// queueDepth returns the number of messages ready in a queue
+func queueDepth(conn *ConnectionManager, queue string) (int, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return 0, err
+	}
+	defer ch.Close()
+
+	q, err := ch.QueueDeclarePassive(queue, false, false, false, false, nil)
+	if err != nil {
+		return 0, err
+	}
+	return q.Messages, nil
+}
@@ -0,0 +1,109 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/rabbitmq/envelope"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestPartitionKey(t *testing.T) {
+	structured, err := newCustomerCloudEvent(testOutboxEvent()).publishing(FormatStructured)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		d    amqp.Delivery
+		want string
+	}{
+		{"order event", amqp.Delivery{Body: []byte(`{"order":{"orderId":1,"customerId":3}}`)}, "customer:3"},
+		{"customer event", amqp.Delivery{Body: []byte(`{"customer":{"ID":7}}`)}, "customer:7"},
+		{"structured CloudEvent", delivery(structured), "customer:7"},
+		{"product event", amqp.Delivery{MessageId: "msg-1", Body: []byte(`{"product":{"productId":2}}`)}, "msg-1"},
+		{"invalid JSON", amqp.Delivery{MessageId: "msg-2", Body: []byte(`not json`)}, "msg-2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := partitionKey(tt.d); got != tt.want {
+				t.Errorf("partitionKey = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPartitionIsStable(t *testing.T) {
+	for _, key := range []string{"customer:1", "customer:2", "msg-1"} {
+		first := partition(key, 8)
+		if first < 0 || first >= 8 {
+			t.Fatalf("partition(%q) = %d, out of range", key, first)
+		}
+		for range 10 {
+			if got := partition(key, 8); got != first {
+				t.Fatalf("partition(%q) moved from %d to %d", key, first, got)
+			}
+		}
+	}
+}
+
+func TestConsumeKeepsCustomerOrder(t *testing.T) {
+	const customers, perCustomer = 4, 20
+
+	var mu sync.Mutex
+	seen := map[uint][]int{}
+	router := NewEventRouter()
+	router.RegisterHandler("order.updated", func(ctx context.Context, msg envelope.Envelope) error {
+		var event struct {
+			Seq   int `json:"seq"`
+			Order struct {
+				CustomerID uint `json:"customerId"`
+			} `json:"order"`
+		}
+		if err := json.Unmarshal(msg.Body, &event); err != nil {
+			return err
+		}
+		// Later events of other customers overtake this one if they share its worker
+		time.Sleep(time.Duration(event.Seq%3) * time.Millisecond)
+		mu.Lock()
+		seen[event.Order.CustomerID] = append(seen[event.Order.CustomerID], event.Seq)
+		mu.Unlock()
+		return nil
+	})
+
+	inFlight := testutil.ToFloat64(eventsInFlight)
+	msgs := make(chan amqp.Delivery, customers*perCustomer)
+	for seq := range perCustomer {
+		for customer := 1; customer <= customers; customer++ {
+			msgs <- amqp.Delivery{
+				RoutingKey: "order.updated",
+				MessageId:  fmt.Sprintf("%d-%d", customer, seq),
+				Body:       []byte(fmt.Sprintf(`{"seq":%d,"order":{"customerId":%d}}`, seq, customer)),
+			}
+		}
+	}
+	close(msgs)
+
+	router.consume(nil, "customers.events", msgs, 3, 2)
+
+	for customer := uint(1); customer <= customers; customer++ {
+		got := seen[customer]
+		if len(got) != perCustomer {
+			t.Fatalf("expected %d events for customer %d, got %d", perCustomer, customer, len(got))
+		}
+		for i, seq := range got {
+			if seq != i {
+				t.Fatalf("expected the events of customer %d in order, got %v", customer, got)
+			}
+		}
+	}
+	if got := testutil.ToFloat64(eventsInFlight); got != inFlight {
+		t.Errorf("expected the in-flight gauge back to %v, got %v", inFlight, got)
+	}
+}
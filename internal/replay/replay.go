@@ -0,0 +1,206 @@
+// Package replay republishes existing customers as customer.snapshot events, so that
+// a service subscribing late can learn about customers created before it joined.
+package replay
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/PayeTonKawa-EPSI-2025/Common-V2/events"
+	database "github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/db"
+	localModels "github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/models"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/rabbitmq"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CustomerSnapshot is the type of the events carrying the current state of a customer
+const CustomerSnapshot = rabbitmq.CustomerSnapshot
+
+// DefaultName is the checkpoint used when none is given
+const DefaultName = "customers"
+
+// Options tune a replay
+type Options struct {
+	// Name of the checkpoint, replays with different names progress independently
+	Name string `json:"name,omitempty" default:"customers" doc:"Checkpoint name"`
+	// BatchSize is the number of customers read and enqueued per transaction
+	BatchSize int `json:"batch_size,omitempty" minimum:"1" maximum:"10000" default:"500" doc:"Customers per batch"`
+	// Rate is the maximum number of events per second, 0 means unlimited
+	Rate float64 `json:"rate,omitempty" minimum:"0" default:"100" doc:"Maximum events per second, 0 for no limit"`
+	// Restart ignores the checkpoint and replays every customer again
+	Restart bool `json:"restart,omitempty" doc:"Ignore the checkpoint and start from the first customer"`
+	// DryRun counts the customers that would be replayed without publishing anything
+	DryRun bool `json:"dry_run,omitempty" doc:"Only count the customers that would be republished"`
+}
+
+// Result summarises a replay
+type Result struct {
+	Name           string        `json:"name"`
+	Published      int64         `json:"published" doc:"Events enqueued by this run, or that would be in dry-run mode"`
+	LastCustomerID uint          `json:"last_customer_id"`
+	DryRun         bool          `json:"dry_run"`
+	Duration       time.Duration `json:"duration" doc:"Duration in nanoseconds"`
+}
+
+func (o *Options) setDefaults() {
+	if o.Name == "" {
+		o.Name = DefaultName
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = 500
+	}
+}
+
+// DefaultOptions returns the options used when nothing is specified
+func DefaultOptions() Options {
+	return Options{Name: DefaultName, BatchSize: 500, Rate: 100}
+}
+
+// Publisher publishes customer events. Events published with the context of a
+// transaction must only go out if the transaction commits.
+type Publisher interface {
+	PublishCustomerEvent(ctx context.Context, event rabbitmq.CustomerChangeEvent) error
+}
+
+// Replayer republishes the customers stored in db with publisher and keeps track of its
+// progress in the replay_checkpoints table
+type Replayer struct {
+	db        *gorm.DB
+	publisher Publisher
+	now       func() time.Time
+	newID     func() string
+}
+
+// NewReplayer creates a replayer reading customers from db and publishing them with publisher
+func NewReplayer(db *gorm.DB, publisher Publisher, now func() time.Time, newID func() string) *Replayer {
+	return &Replayer{db: db, publisher: publisher, now: now, newID: newID}
+}
+
+// Run republishes the customers after the checkpoint, in id order, until all of them are
+// done or ctx is cancelled. Each batch is published in the same transaction as the
+// checkpoint update, so with the outbox publisher a resumed replay neither skips nor
+// repeats customers. The relay publishes them as usual.
+func (r *Replayer) Run(ctx context.Context, opts Options) (Result, error) {
+	db := r.db
+	opts.setDefaults()
+	start := time.Now()
+	result := Result{Name: opts.Name, DryRun: opts.DryRun}
+
+	checkpoint := localModels.ReplayCheckpoint{Name: opts.Name}
+	if !opts.Restart {
+		err := db.WithContext(ctx).First(&checkpoint).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return result, err
+		}
+	}
+	result.LastCustomerID = checkpoint.LastCustomerID
+	if checkpoint.LastCustomerID > 0 {
+		log.Printf("Resuming replay %s after customer %d", opts.Name, checkpoint.LastCustomerID)
+	}
+
+	// A resumed replay keeps counting, a new one starts from zero
+	if checkpoint.CompletedAt != nil {
+		checkpoint.Published = 0
+		checkpoint.CompletedAt = nil
+	}
+
+	for {
+		batchStart := time.Now()
+
+		var customers []localModels.Customer
+		err := db.WithContext(ctx).
+			Where("id > ?", result.LastCustomerID).
+			Order("id").
+			Limit(opts.BatchSize).
+			Find(&customers).Error
+		if err != nil {
+			return result, err
+		}
+		if len(customers) == 0 {
+			break
+		}
+		last := customers[len(customers)-1].ID
+
+		if !opts.DryRun {
+			err = db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+				txCtx := database.WithTx(ctx, tx)
+				for _, customer := range customers {
+					if err := r.publisher.PublishCustomerEvent(txCtx, r.snapshot(customer)); err != nil {
+						return err
+					}
+				}
+				checkpoint.LastCustomerID = last
+				checkpoint.Published += int64(len(customers))
+				return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&checkpoint).Error
+			})
+			if err != nil {
+				return result, err
+			}
+		}
+
+		result.LastCustomerID = last
+		result.Published += int64(len(customers))
+		log.Printf("Replay %s: %d customers up to %d", opts.Name, result.Published, last)
+
+		if err := throttle(ctx, opts.Rate, len(customers), time.Since(batchStart)); err != nil {
+			return result, err
+		}
+	}
+
+	if !opts.DryRun {
+		now := time.Now()
+		checkpoint.CompletedAt = &now
+		if err := db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(&checkpoint).Error; err != nil {
+			return result, err
+		}
+	}
+
+	result.Duration = time.Since(start)
+	log.Printf("Replay %s done: %d customers in %s (dry run: %t)", opts.Name, result.Published, result.Duration, opts.DryRun)
+	return result, nil
+}
+
+// snapshot returns the event carrying the current state of a customer
+func (r *Replayer) snapshot(customer localModels.Customer) rabbitmq.CustomerChangeEvent {
+	return rabbitmq.CustomerChangeEvent{
+		CustomerEvent: events.CustomerEvent{
+			Type:      CustomerSnapshot,
+			Customer:  customer.Customer,
+			Timestamp: r.now(),
+		},
+		ID: r.newID(),
+	}
+}
+
+// throttle waits so that count events sent in elapsed time stay under rate per second
+func throttle(ctx context.Context, rate float64, count int, elapsed time.Duration) error {
+	if rate <= 0 {
+		return ctx.Err()
+	}
+	wait := time.Duration(float64(count)/rate*float64(time.Second)) - elapsed
+	if wait <= 0 {
+		return ctx.Err()
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(wait):
+		return nil
+	}
+}
+
+// Status returns the checkpoint of a replay, or nil if it never ran
+func (r *Replayer) Status(ctx context.Context, name string) (*localModels.ReplayCheckpoint, error) {
+	var checkpoint localModels.ReplayCheckpoint
+	err := r.db.WithContext(ctx).First(&checkpoint, "name = ?", name).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &checkpoint, nil
+}
@@ -0,0 +1,117 @@
+package replay
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	database "github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/db"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/rabbitmq"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// recordingPublisher records the events published and whether they joined a transaction
+type recordingPublisher struct {
+	events       []rabbitmq.CustomerChangeEvent
+	transactions []bool
+}
+
+func (p *recordingPublisher) PublishCustomerEvent(ctx context.Context, event rabbitmq.CustomerChangeEvent) error {
+	p.events = append(p.events, event)
+	p.transactions = append(p.transactions, database.Conn(ctx, nil) != nil)
+	return nil
+}
+
+func TestRunDryRunResumesFromCheckpoint(t *testing.T) {
+	dbMock, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: dbMock}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open gorm DB: %v", err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "replay_checkpoints" WHERE "replay_checkpoints"."name" = $1`)).
+		WithArgs("customers", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "last_customer_id", "published"}).AddRow("customers", 10, 10))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "customers" WHERE id > $1`)).
+		WithArgs(10, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow(11, "a").AddRow(12, "b"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "customers" WHERE id > $1`)).
+		WithArgs(12, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	replayer := NewReplayer(db, nil, time.Now, func() string { return "" })
+	result, err := replayer.Run(context.Background(), Options{BatchSize: 2, DryRun: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Published != 2 || result.LastCustomerID != 12 {
+		t.Errorf("result = %+v, want 2 customers up to 12", result)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestRunPublishesWithCheckpoint(t *testing.T) {
+	dbMock, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: dbMock}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open gorm DB: %v", err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "customers" WHERE id > $1`)).
+		WithArgs(0, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow(1, "a"))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "replay_checkpoints"`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "customers" WHERE id > $1`)).
+		WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "replay_checkpoints"`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	publisher := &recordingPublisher{}
+	replayer := NewReplayer(db, publisher, time.Now, func() string { return "event-1" })
+	if _, err := replayer.Run(context.Background(), Options{BatchSize: 2, Restart: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(publisher.events) != 1 || publisher.events[0].Type != CustomerSnapshot || publisher.events[0].ID != "event-1" {
+		t.Fatalf("expected a snapshot of customer 1, got %+v", publisher.events)
+	}
+	if !publisher.transactions[0] {
+		t.Error("expected the snapshot to be published in the checkpoint transaction")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	start := time.Now()
+	if err := throttle(context.Background(), 100, 5, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("throttle waited %s, want at least 50ms for 5 events at 100/s", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := throttle(ctx, 1, 10, 0); err == nil {
+		t.Error("expected the cancelled context to stop the replay")
+	}
+}
@@ -0,0 +1,124 @@
+// Package repository stores customers and the projections of other services. Operations
+// depend on the CustomerRepository interface, implemented on Postgres with GORM and in
+// memory for tests, event handlers on the ProjectionRepository one.
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/PayeTonKawa-EPSI-2025/Common-V2/models"
+	localModels "github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/models"
+)
+
+var (
+	// ErrNotFound is returned when no customer has the requested ID
+	ErrNotFound = errors.New("customer not found")
+	// ErrStale is returned when a customer was modified since it was read, i.e. when its
+	// stored version no longer matches the version of the customer being written
+	ErrStale = errors.New("customer modified concurrently")
+)
+
+// ConflictError is returned when a write would give a customer the value of a unique
+// field already used by another customer
+type ConflictError struct {
+	// Field is the public name of the conflicting field, e.g. username, empty when unknown
+	Field string
+	Value string
+}
+
+func (e *ConflictError) Error() string {
+	if e.Field == "" {
+		return "customer conflicts with an existing customer"
+	}
+	return fmt.Sprintf("customer %s %q already taken", e.Field, e.Value)
+}
+
+// Sort fields accepted by ListFilter.Sort
+const (
+	SortByUsername  = "username"
+	SortByName      = "name"
+	SortByCreatedAt = "created_at"
+)
+
+// ListFilter selects a page of customers
+type ListFilter struct {
+	City           string
+	PostalCode     string
+	CompanyName    string
+	CreatedAfter   time.Time
+	CreatedBefore  time.Time
+	IncludeDeleted bool
+
+	// Sort is one of the SortBy fields, ties are broken by ID
+	Sort string
+	Desc bool
+	// After restricts the page to the customers located after this position
+	After *Position
+	Limit int
+}
+
+// Position locates a customer in a sorted list: the value of its sort field (a string,
+// or a time.Time when sorting by creation date) and its ID
+type Position struct {
+	Value any
+	ID    uint
+}
+
+// SearchResult is a customer matching a search along with its relevance
+type SearchResult struct {
+	Customer models.Customer
+	Rank     float32
+	// Snippet is the matching text with hits wrapped in <mark> tags
+	Snippet string
+}
+
+// DeleteOptions tunes CustomerRepository.Delete
+type DeleteOptions struct {
+	// Purge removes the customer and its order links for good instead of soft-deleting it
+	Purge bool
+	// CheckVersion fails with ErrStale when the customer changed since it was read
+	CheckVersion bool
+}
+
+// OrderFilter selects orders of a customer from the local order projection
+type OrderFilter struct {
+	OrderedAfter  time.Time
+	OrderedBefore time.Time
+	// Before restricts the orders to those placed before this position, whose value is an order date
+	Before *Position
+	// Limit is the maximum number of orders returned, 0 for all of them
+	Limit int
+}
+
+// CustomerRepository reads and writes customers, and reads their orders from the local
+// order projection
+type CustomerRepository interface {
+	// Get returns the customer with the given ID, soft-deleted ones included when withDeleted is set
+	Get(ctx context.Context, id uint, withDeleted bool) (localModels.Customer, error)
+	// List returns the customers matching the filter, in order
+	List(ctx context.Context, filter ListFilter) ([]models.Customer, error)
+	// Search returns the customers whose name, username, company or city contain words
+	// starting with every term, most relevant first. Terms hold letters and digits only.
+	Search(ctx context.Context, terms []string, limit int) ([]SearchResult, error)
+	// UsernameTaken reports whether a customer uses username, whatever its case
+	UsernameTaken(ctx context.Context, username string) (bool, error)
+
+	// Create inserts a customer and sets its ID and timestamps
+	Create(ctx context.Context, customer *localModels.Customer) error
+	// Update writes the given columns of a customer if its stored version is still
+	// customer.Version, otherwise it fails with ErrStale. The version is then incremented
+	// and customer reloaded.
+	Update(ctx context.Context, customer *localModels.Customer, columns ...string) error
+	// Delete soft-deletes or purges a customer
+	Delete(ctx context.Context, customer localModels.Customer, opts DeleteOptions) error
+
+	// CustomerOrders returns the projected orders of a customer, newest first
+	CustomerOrders(ctx context.Context, customerID uint, filter OrderFilter) ([]localModels.Order, error)
+
+	// WithinTransaction runs fn with a context carrying a transaction: the writes made
+	// with this context are committed together when fn succeeds and discarded when it fails
+	WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
@@ -0,0 +1,245 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/PayeTonKawa-EPSI-2025/Common-V2/models"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/db"
+	localModels "github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/models"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// uniqueViolation is the Postgres error code of a unique constraint violation
+const uniqueViolation = "23505"
+
+// uniqueField is a customer field covered by a unique index
+type uniqueField struct {
+	name  string
+	value func(c models.Customer) string
+}
+
+// uniqueFields maps the unique indexes on customers to the field they cover
+var uniqueFields = map[string]uniqueField{
+	db.UsernameIndex: {name: "username", value: func(c models.Customer) string { return c.Username }},
+}
+
+const searchQuery = `SELECT customers.*,
+	ts_rank(search_vector, query) AS rank,
+	ts_headline('` + db.SearchConfig + `',
+		concat_ws(' ', name, username, company_company_name, address_city),
+		query, 'StartSel=<mark>, StopSel=</mark>') AS snippet
+FROM customers, to_tsquery('` + db.SearchConfig + `', ?) AS query
+WHERE customers.deleted_at IS NULL AND search_vector @@ query
+ORDER BY rank DESC, customers.id
+LIMIT ?`
+
+// GormCustomerRepository stores customers in Postgres. Its methods join the transaction
+// carried by their context, see WithinTransaction.
+type GormCustomerRepository struct {
+	db *gorm.DB
+}
+
+// NewGormCustomerRepository creates a repository on db
+func NewGormCustomerRepository(db *gorm.DB) *GormCustomerRepository {
+	return &GormCustomerRepository{db: db}
+}
+
+// conn returns the connection to use for ctx, the current transaction if any
+func (r *GormCustomerRepository) conn(ctx context.Context) *gorm.DB {
+	return db.Conn(ctx, r.db)
+}
+
+func (r *GormCustomerRepository) Get(ctx context.Context, id uint, withDeleted bool) (localModels.Customer, error) {
+	query := r.conn(ctx)
+	if withDeleted {
+		query = query.Unscoped()
+	}
+
+	var customer localModels.Customer
+	err := query.First(&customer, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return customer, ErrNotFound
+	}
+	return customer, err
+}
+
+func (r *GormCustomerRepository) List(ctx context.Context, filter ListFilter) ([]models.Customer, error) {
+	query := r.conn(ctx).Model(&models.Customer{})
+	if filter.IncludeDeleted {
+		query = query.Unscoped()
+	}
+	if filter.City != "" {
+		query = query.Where("address_city = ?", filter.City)
+	}
+	if filter.PostalCode != "" {
+		query = query.Where("address_postal_code = ?", filter.PostalCode)
+	}
+	if filter.CompanyName != "" {
+		query = query.Where("company_company_name = ?", filter.CompanyName)
+	}
+	if !filter.CreatedAfter.IsZero() {
+		query = query.Where("created_at > ?", filter.CreatedAfter)
+	}
+	if !filter.CreatedBefore.IsZero() {
+		query = query.Where("created_at < ?", filter.CreatedBefore)
+	}
+
+	column := filter.Sort
+	if column == "" {
+		column = SortByCreatedAt
+	}
+	op, direction := ">", "ASC"
+	if filter.Desc {
+		op, direction = "<", "DESC"
+	}
+
+	// Keyset pagination on (sort column, id)
+	if filter.After != nil {
+		query = query.Where(fmt.Sprintf("(%s, id) %s (?, ?)", column, op), filter.After.Value, filter.After.ID)
+	}
+
+	var customers []models.Customer
+	err := query.
+		Order(fmt.Sprintf("%s %s, id %s", column, direction, direction)).
+		Limit(filter.Limit).
+		Find(&customers).Error
+	return customers, err
+}
+
+// searchRow is a customer along with its search metadata
+type searchRow struct {
+	models.Customer
+	Rank    float32
+	Snippet string
+}
+
+func (r *GormCustomerRepository) Search(ctx context.Context, terms []string, limit int) ([]SearchResult, error) {
+	// Prefix tsquery: "hél dup" -> "hél:* & dup:*"
+	words := make([]string, 0, len(terms))
+	for _, term := range terms {
+		words = append(words, term+":*")
+	}
+
+	var rows []searchRow
+	if err := r.conn(ctx).Raw(searchQuery, strings.Join(words, " & "), limit).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	results := make([]SearchResult, 0, len(rows))
+	for _, row := range rows {
+		results = append(results, SearchResult{Customer: row.Customer, Rank: row.Rank, Snippet: row.Snippet})
+	}
+	return results, nil
+}
+
+func (r *GormCustomerRepository) UsernameTaken(ctx context.Context, username string) (bool, error) {
+	var count int64
+	err := r.conn(ctx).Model(&localModels.Customer{}).
+		Where("lower(username) = lower(?)", username).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (r *GormCustomerRepository) Create(ctx context.Context, customer *localModels.Customer) error {
+	return translateError(r.conn(ctx).Create(customer).Error, customer.Customer)
+}
+
+func (r *GormCustomerRepository) Update(ctx context.Context, customer *localModels.Customer, columns ...string) error {
+	version := customer.Version
+	customer.Version++
+
+	// Unscoped so that a soft-deleted customer can be restored
+	result := r.conn(ctx).Unscoped().Model(customer).
+		Select(append(columns, "version")).
+		Where("version = ?", version).
+		Updates(customer)
+	if result.Error != nil {
+		customer.Version = version
+		return translateError(result.Error, customer.Customer)
+	}
+	if result.RowsAffected == 0 {
+		customer.Version = version
+		return ErrStale
+	}
+
+	return r.conn(ctx).Unscoped().First(customer, customer.ID).Error
+}
+
+func (r *GormCustomerRepository) Delete(ctx context.Context, customer localModels.Customer, opts DeleteOptions) error {
+	query := r.conn(ctx)
+	if opts.CheckVersion {
+		query = query.Where("version = ?", customer.Version)
+	}
+
+	var result *gorm.DB
+	if opts.Purge {
+		err := r.conn(ctx).Where("customer_id = ?", customer.ID).Delete(&localModels.CustomerOrder{}).Error
+		if err != nil {
+			return err
+		}
+		result = query.Unscoped().Delete(&customer)
+	} else {
+		// Bump the version so that an ETag read before the delete is stale after a restore
+		result = query.Model(&customer).UpdateColumns(map[string]any{
+			"deleted_at": time.Now(),
+			"version":    gorm.Expr("version + 1"),
+		})
+	}
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 && opts.CheckVersion {
+		return ErrStale
+	}
+	return nil
+}
+
+func (r *GormCustomerRepository) CustomerOrders(ctx context.Context, customerID uint, filter OrderFilter) ([]localModels.Order, error) {
+	query := r.conn(ctx).
+		Joins("JOIN customer_orders ON customer_orders.order_id = orders.id").
+		Where("customer_orders.customer_id = ?", customerID)
+	if !filter.OrderedAfter.IsZero() {
+		query = query.Where("orders.ordered_at > ?", filter.OrderedAfter)
+	}
+	if !filter.OrderedBefore.IsZero() {
+		query = query.Where("orders.ordered_at < ?", filter.OrderedBefore)
+	}
+	if filter.Before != nil {
+		query = query.Where("(orders.ordered_at, orders.id) < (?, ?)", filter.Before.Value, filter.Before.ID)
+	}
+	query = query.Order("orders.ordered_at DESC, orders.id DESC")
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	var orders []localModels.Order
+	err := query.Find(&orders).Error
+	return orders, err
+}
+
+func (r *GormCustomerRepository) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return r.conn(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(db.WithTx(ctx, tx))
+	})
+}
+
+// translateError turns a unique violation raised while writing customer into a ConflictError.
+// Other errors are returned as is.
+func translateError(err error, customer models.Customer) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != uniqueViolation {
+		return err
+	}
+
+	field, ok := uniqueFields[pgErr.ConstraintName]
+	if !ok {
+		// Unknown index, the conflicting field cannot be named
+		return &ConflictError{}
+	}
+	return &ConflictError{Field: field.name, Value: field.value(customer)}
+}
@@ -0,0 +1,291 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/PayeTonKawa-EPSI-2025/Common-V2/events"
+	"github.com/PayeTonKawa-EPSI-2025/Common-V2/models"
+	localModels "github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/models"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/rabbitmq"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/repository"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func setupMockRepository(t *testing.T) (*repository.GormCustomerRepository, sqlmock.Sqlmock) {
+	gormDB, mock := setupMockDB(t)
+	return repository.NewGormCustomerRepository(gormDB), mock
+}
+
+func setupMockDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	dbMock, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: dbMock,
+	}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open gorm DB: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("unfulfilled sqlmock expectations: %v", err)
+		}
+	})
+	return gormDB, mock
+}
+
+// expectOutboxEvent expects the customer event to be written to the outbox
+func expectOutboxEvent(mock sqlmock.Sqlmock, routingKey string) {
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "outbox_events"`)).
+		WithArgs(routingKey, sqlmock.AnyArg(), sqlmock.AnyArg(), nil, 0, "", nil, "", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+}
+
+func TestGormGet(t *testing.T) {
+	repo, mock := setupMockRepository(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(
+		`SELECT * FROM "customers" WHERE "customers"."id" = $1 AND "customers"."deleted_at" IS NULL ORDER BY "customers"."id" LIMIT $2`,
+	)).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "version"}).AddRow(1, "jdoe", 2))
+
+	customer, err := repo.Get(context.Background(), 1, false)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if customer.Username != "jdoe" || customer.Version != 2 {
+		t.Errorf("unexpected customer %+v", customer)
+	}
+}
+
+func TestGormGetNotFound(t *testing.T) {
+	repo, mock := setupMockRepository(t)
+
+	// Soft-deleted customers are included on demand
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "customers" WHERE "customers"."id" = $1 ORDER BY "customers"."id" LIMIT $2`)).
+		WithArgs(1, 1).
+		WillReturnError(gorm.ErrRecordNotFound)
+
+	_, err := repo.Get(context.Background(), 1, true)
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestGormList(t *testing.T) {
+	repo, mock := setupMockRepository(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(
+		`SELECT * FROM "customers" WHERE address_city = $1 AND (name, id) < ($2, $3) AND "customers"."deleted_at" IS NULL ORDER BY name DESC, id DESC LIMIT $4`,
+	)).
+		WithArgs("Paris", "John DOE", 1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "name"}).AddRow(2, "asmith", "Alice SMITH"))
+
+	customers, err := repo.List(context.Background(), repository.ListFilter{
+		City:  "Paris",
+		Sort:  repository.SortByName,
+		Desc:  true,
+		After: &repository.Position{Value: "John DOE", ID: 1},
+		Limit: 2,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(customers) != 1 || customers[0].Username != "asmith" {
+		t.Errorf("unexpected customers %+v", customers)
+	}
+}
+
+func TestGormSearch(t *testing.T) {
+	repo, mock := setupMockRepository(t)
+
+	mock.ExpectQuery(`FROM customers, to_tsquery\('customers_search', \$1\) AS query`).
+		WithArgs("helene:* & dup:*", 20).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "name", "rank", "snippet"}).
+			AddRow(1, "hdupont", "Hélène DUPONT", 0.6, "<mark>Hélène</mark> <mark>DUPONT</mark>"))
+
+	results, err := repo.Search(context.Background(), []string{"helene", "dup"}, 20)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(results) != 1 || results[0].Customer.Username != "hdupont" || results[0].Snippet == "" {
+		t.Errorf("unexpected results %+v", results)
+	}
+}
+
+func TestGormUsernameTaken(t *testing.T) {
+	repo, mock := setupMockRepository(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "customers" WHERE lower(username) = lower($1) AND "customers"."deleted_at" IS NULL`)).
+		WithArgs("JDoe").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	taken, err := repo.UsernameTaken(context.Background(), "JDoe")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !taken {
+		t.Error("expected username to be taken")
+	}
+}
+
+func TestGormCreateWithEvent(t *testing.T) {
+	gormDB, mock := setupMockDB(t)
+	repo := repository.NewGormCustomerRepository(gormDB)
+	publisher := rabbitmq.NewOutboxPublisher(gormDB)
+
+	// The event is written in the transaction of the customer
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "customers"`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	expectOutboxEvent(mock, "customer.created")
+	mock.ExpectCommit()
+
+	customer := localModels.Customer{Customer: models.Customer{Username: "jdoe"}, Version: 1}
+	err := repo.WithinTransaction(context.Background(), func(ctx context.Context) error {
+		if err := repo.Create(ctx, &customer); err != nil {
+			return err
+		}
+		return publisher.PublishCustomerEvent(ctx, rabbitmq.CustomerChangeEvent{
+			CustomerEvent: events.CustomerEvent{Type: events.CustomerCreated, Customer: customer.Customer},
+		})
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if customer.ID != 1 {
+		t.Errorf("expected ID 1, got %d", customer.ID)
+	}
+}
+
+func TestGormCreateUsernameTaken(t *testing.T) {
+	repo, mock := setupMockRepository(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "customers"`)).
+		WillReturnError(&pgconn.PgError{Code: "23505", ConstraintName: "idx_customers_username_lower"})
+	mock.ExpectRollback()
+
+	customer := localModels.Customer{Customer: models.Customer{Username: "JDoe"}, Version: 1}
+	err := repo.Create(context.Background(), &customer)
+
+	var conflict *repository.ConflictError
+	if !errors.As(err, &conflict) || conflict.Field != "username" || conflict.Value != "JDoe" {
+		t.Fatalf("expected a username conflict, got %v", err)
+	}
+}
+
+func TestGormUpdate(t *testing.T) {
+	repo, mock := setupMockRepository(t)
+
+	// Only the given columns are written, if nobody changed the customer meanwhile
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "customers" SET "updated_at"=$1,"address_city"=$2,"version"=$3 WHERE version = $4 AND "id" = $5`)).
+		WithArgs(sqlmock.AnyArg(), "Lyon", 3, 2, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "customers" WHERE "customers"."id" = $1 AND "customers"."id" = $2 ORDER BY "customers"."id" LIMIT $3`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "address_city", "version"}).AddRow(1, "jdoe", "Lyon", 3))
+
+	customer := localModels.Customer{Customer: models.Customer{Username: "jdoe", Address: models.Address{City: "Lyon"}}, Version: 2}
+	customer.ID = 1
+	if err := repo.Update(context.Background(), &customer, "address_city"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if customer.Version != 3 {
+		t.Errorf("expected version 3, got %d", customer.Version)
+	}
+}
+
+func TestGormUpdateStale(t *testing.T) {
+	repo, mock := setupMockRepository(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "customers"`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	customer := localModels.Customer{Customer: models.Customer{Username: "jdoe"}, Version: 2}
+	customer.ID = 1
+	err := repo.Update(context.Background(), &customer, "username")
+	if !errors.Is(err, repository.ErrStale) {
+		t.Fatalf("expected ErrStale, got %v", err)
+	}
+	if customer.Version != 2 {
+		t.Errorf("expected version to be left at 2, got %d", customer.Version)
+	}
+}
+
+func TestGormSoftDelete(t *testing.T) {
+	repo, mock := setupMockRepository(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(
+		`UPDATE "customers" SET "deleted_at"=$1,"version"=version + 1 WHERE version = $2 AND "customers"."deleted_at" IS NULL AND "id" = $3`)).
+		WithArgs(sqlmock.AnyArg(), 2, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	customer := localModels.Customer{Version: 2}
+	customer.ID = 1
+	if err := repo.Delete(context.Background(), customer, repository.DeleteOptions{CheckVersion: true}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func TestGormPurge(t *testing.T) {
+	repo, mock := setupMockRepository(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "customer_orders" WHERE customer_id = $1`)).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "customers" WHERE "customers"."id" = $1`)).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	customer := localModels.Customer{Version: 1}
+	customer.ID = 1
+	customer.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	if err := repo.Delete(context.Background(), customer, repository.DeleteOptions{Purge: true}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func TestGormCustomerOrders(t *testing.T) {
+	repo, mock := setupMockRepository(t)
+
+	before := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta(
+		`FROM "orders" JOIN customer_orders ON customer_orders.order_id = orders.id WHERE customer_orders.customer_id = $1 AND (orders.ordered_at, orders.id) < ($2, $3) AND "orders"."deleted_at" IS NULL ORDER BY orders.ordered_at DESC, orders.id DESC LIMIT $4`,
+	)).
+		WithArgs(1, before, 7, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ordered_at"}).
+			AddRow(6, before).
+			AddRow(5, before.Add(-time.Hour)))
+
+	orders, err := repo.CustomerOrders(context.Background(), 1, repository.OrderFilter{
+		Before: &repository.Position{Value: before, ID: 7},
+		Limit:  2,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(orders) != 2 || orders[0].ID != 6 {
+		t.Errorf("expected orders 6 and 5, got %+v", orders)
+	}
+}
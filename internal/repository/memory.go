@@ -0,0 +1,333 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/PayeTonKawa-EPSI-2025/Common-V2/models"
+	localModels "github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/models"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+	"gorm.io/gorm"
+)
+
+// MemoryCustomerRepository keeps customers in memory. It follows the rules of the
+// Postgres repository (soft deletes, versions, case-insensitive unique usernames) and
+// is meant for tests.
+type MemoryCustomerRepository struct {
+	// tx serialises transactions, which are not reentrant
+	tx sync.Mutex
+
+	mu        sync.Mutex
+	customers map[uint]localModels.Customer
+	nextID    uint
+	orders    map[uint][]localModels.Order
+}
+
+// NewMemoryCustomerRepository creates a repository holding the given customers.
+// Customers without an ID are given one.
+func NewMemoryCustomerRepository(customers ...localModels.Customer) *MemoryCustomerRepository {
+	r := &MemoryCustomerRepository{
+		customers: map[uint]localModels.Customer{},
+		orders:    map[uint][]localModels.Order{},
+	}
+	for _, customer := range customers {
+		if customer.ID == 0 {
+			r.nextID++
+			customer.ID = r.nextID
+		}
+		if customer.Version == 0 {
+			customer.Version = 1
+		}
+		r.nextID = max(r.nextID, customer.ID)
+		r.customers[customer.ID] = customer
+	}
+	return r
+}
+
+// AddOrders adds orders of a customer to the order projection
+func (r *MemoryCustomerRepository) AddOrders(customerID uint, orders ...localModels.Order) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.orders[customerID] = append(r.orders[customerID], orders...)
+}
+
+func (r *MemoryCustomerRepository) Get(ctx context.Context, id uint, withDeleted bool) (localModels.Customer, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	customer, ok := r.customers[id]
+	if !ok || (customer.DeletedAt.Valid && !withDeleted) {
+		return localModels.Customer{}, ErrNotFound
+	}
+	return customer, nil
+}
+
+func (r *MemoryCustomerRepository) List(ctx context.Context, filter ListFilter) ([]models.Customer, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var customers []models.Customer
+	for _, customer := range r.customers {
+		c := customer.Customer
+		switch {
+		case c.DeletedAt.Valid && !filter.IncludeDeleted,
+			filter.City != "" && c.Address.City != filter.City,
+			filter.PostalCode != "" && c.Address.PostalCode != filter.PostalCode,
+			filter.CompanyName != "" && c.Company.CompanyName != filter.CompanyName,
+			!filter.CreatedAfter.IsZero() && !c.CreatedAt.After(filter.CreatedAfter),
+			!filter.CreatedBefore.IsZero() && !c.CreatedAt.Before(filter.CreatedBefore):
+			continue
+		}
+		if filter.After != nil {
+			position := comparePosition(c, filter.Sort, *filter.After)
+			if (filter.Desc && position >= 0) || (!filter.Desc && position <= 0) {
+				continue
+			}
+		}
+		customers = append(customers, c)
+	}
+
+	slices.SortFunc(customers, func(a, b models.Customer) int {
+		order := comparePosition(a, filter.Sort, Position{Value: sortValue(b, filter.Sort), ID: b.ID})
+		if filter.Desc {
+			return -order
+		}
+		return order
+	})
+	return customers[:min(len(customers), filter.Limit)], nil
+}
+
+// sortValue returns the value of the sort field of a customer, as in a Position
+func sortValue(c models.Customer, sort string) any {
+	switch sort {
+	case SortByUsername:
+		return c.Username
+	case SortByName:
+		return c.Name
+	default:
+		return c.CreatedAt
+	}
+}
+
+// comparePosition compares the position of a customer in a list sorted by sort with p
+func comparePosition(c models.Customer, sort string, p Position) int {
+	var order int
+	switch value := sortValue(c, sort).(type) {
+	case time.Time:
+		after, _ := p.Value.(time.Time)
+		order = value.Compare(after)
+	case string:
+		after, _ := p.Value.(string)
+		order = strings.Compare(value, after)
+	}
+	if order != 0 {
+		return order
+	}
+	return cmp.Compare(c.ID, p.ID)
+}
+
+// searchFolding lower-cases text and strips accents, as the customers_search configuration does
+var searchFolding = transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+
+func foldSearchText(s string) string {
+	folded, _, _ := transform.String(searchFolding, strings.ToLower(s))
+	return folded
+}
+
+// Search matches terms against the beginning of words. Hits in the name or username weigh
+// more than hits in the company, which weigh more than hits in the city.
+func (r *MemoryCustomerRepository) Search(ctx context.Context, terms []string, limit int) ([]SearchResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var results []SearchResult
+	for _, customer := range r.customers {
+		c := customer.Customer
+		if c.DeletedAt.Valid {
+			continue
+		}
+
+		fields := []struct {
+			text   string
+			weight float32
+		}{{c.Name, 1}, {c.Username, 1}, {c.Company.CompanyName, 0.4}, {c.Address.City, 0.2}}
+
+		var rank float32
+		var snippet []string
+		matchesAll := true
+		for _, term := range terms {
+			term = foldSearchText(term)
+			matched := false
+			for _, field := range fields {
+				for _, word := range strings.Fields(field.text) {
+					if strings.HasPrefix(foldSearchText(word), term) {
+						rank += field.weight
+						matched = true
+					}
+				}
+			}
+			matchesAll = matchesAll && matched
+		}
+		if !matchesAll {
+			continue
+		}
+
+		for _, field := range fields {
+			for _, word := range strings.Fields(field.text) {
+				if slices.ContainsFunc(terms, func(term string) bool {
+					return strings.HasPrefix(foldSearchText(word), foldSearchText(term))
+				}) {
+					word = "<mark>" + word + "</mark>"
+				}
+				snippet = append(snippet, word)
+			}
+		}
+		results = append(results, SearchResult{Customer: c, Rank: rank, Snippet: strings.Join(snippet, " ")})
+	}
+
+	slices.SortFunc(results, func(a, b SearchResult) int {
+		if order := cmp.Compare(b.Rank, a.Rank); order != 0 {
+			return order
+		}
+		return cmp.Compare(a.Customer.ID, b.Customer.ID)
+	})
+	return results[:min(len(results), limit)], nil
+}
+
+func (r *MemoryCustomerRepository) UsernameTaken(ctx context.Context, username string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.usernameTakenLocked(username, 0), nil
+}
+
+// usernameTakenLocked reports whether a customer other than id uses username, r.mu must be held
+func (r *MemoryCustomerRepository) usernameTakenLocked(username string, id uint) bool {
+	for _, customer := range r.customers {
+		if customer.ID != id && !customer.DeletedAt.Valid && strings.EqualFold(customer.Username, username) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *MemoryCustomerRepository) Create(ctx context.Context, customer *localModels.Customer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.usernameTakenLocked(customer.Username, 0) {
+		return &ConflictError{Field: "username", Value: customer.Username}
+	}
+
+	r.nextID++
+	now := time.Now()
+	customer.ID = r.nextID
+	customer.CreatedAt = now
+	customer.UpdatedAt = now
+	if customer.Version == 0 {
+		customer.Version = 1
+	}
+	r.customers[customer.ID] = *customer
+	return nil
+}
+
+// Update stores the whole customer: the columns only limit what the Postgres repository writes
+func (r *MemoryCustomerRepository) Update(ctx context.Context, customer *localModels.Customer, columns ...string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.customers[customer.ID]
+	if !ok || stored.Version != customer.Version {
+		return ErrStale
+	}
+	if !customer.DeletedAt.Valid && r.usernameTakenLocked(customer.Username, customer.ID) {
+		return &ConflictError{Field: "username", Value: customer.Username}
+	}
+
+	customer.Version++
+	customer.CreatedAt = stored.CreatedAt
+	customer.UpdatedAt = time.Now()
+	r.customers[customer.ID] = *customer
+	return nil
+}
+
+func (r *MemoryCustomerRepository) Delete(ctx context.Context, customer localModels.Customer, opts DeleteOptions) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.customers[customer.ID]
+	if !ok || (opts.CheckVersion && stored.Version != customer.Version) {
+		if opts.CheckVersion {
+			return ErrStale
+		}
+		return nil
+	}
+
+	if opts.Purge {
+		delete(r.customers, customer.ID)
+		return nil
+	}
+	if !stored.DeletedAt.Valid {
+		stored.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+		stored.Version++
+		r.customers[customer.ID] = stored
+	}
+	return nil
+}
+
+func (r *MemoryCustomerRepository) CustomerOrders(ctx context.Context, customerID uint, filter OrderFilter) ([]localModels.Order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var orders []localModels.Order
+	for _, order := range r.orders[customerID] {
+		switch {
+		case !filter.OrderedAfter.IsZero() && !order.OrderedAt.After(filter.OrderedAfter),
+			!filter.OrderedBefore.IsZero() && !order.OrderedAt.Before(filter.OrderedBefore):
+			continue
+		}
+		if filter.Before != nil {
+			before, _ := filter.Before.Value.(time.Time)
+			if order.OrderedAt.After(before) || (order.OrderedAt.Equal(before) && order.ID >= filter.Before.ID) {
+				continue
+			}
+		}
+		orders = append(orders, order)
+	}
+
+	slices.SortFunc(orders, func(a, b localModels.Order) int {
+		if order := b.OrderedAt.Compare(a.OrderedAt); order != 0 {
+			return order
+		}
+		return cmp.Compare(b.ID, a.ID)
+	})
+	if filter.Limit > 0 {
+		orders = orders[:min(len(orders), filter.Limit)]
+	}
+	return orders, nil
+}
+
+// WithinTransaction restores the customers as they were when fn fails
+func (r *MemoryCustomerRepository) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	r.tx.Lock()
+	defer r.tx.Unlock()
+
+	r.mu.Lock()
+	customers, nextID := maps.Clone(r.customers), r.nextID
+	r.mu.Unlock()
+
+	err := fn(ctx)
+	if err != nil {
+		r.mu.Lock()
+		r.customers, r.nextID = customers, nextID
+		r.mu.Unlock()
+	}
+	return err
+}
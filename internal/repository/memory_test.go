@@ -0,0 +1,49 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/PayeTonKawa-EPSI-2025/Common-V2/models"
+	localModels "github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/models"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/repository"
+)
+
+func TestMemoryTransactionRollback(t *testing.T) {
+	repo := repository.NewMemoryCustomerRepository()
+	failure := errors.New("failure")
+
+	err := repo.WithinTransaction(context.Background(), func(ctx context.Context) error {
+		customer := localModels.Customer{Customer: models.Customer{Username: "jdoe"}}
+		if err := repo.Create(ctx, &customer); err != nil {
+			return err
+		}
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("expected the transaction error, got %v", err)
+	}
+
+	if _, err := repo.Get(context.Background(), 1, true); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected the customer to be discarded, got %v", err)
+	}
+}
+
+func TestMemoryUpdateStale(t *testing.T) {
+	repo := repository.NewMemoryCustomerRepository(localModels.Customer{Customer: models.Customer{Username: "jdoe"}})
+
+	first, _ := repo.Get(context.Background(), 1, false)
+	second := first
+
+	first.Username = "jdoe1"
+	if err := repo.Update(context.Background(), &first, "username"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// second was read before the first update
+	second.Username = "jdoe2"
+	if err := repo.Update(context.Background(), &second, "username"); !errors.Is(err, repository.ErrStale) {
+		t.Fatalf("expected ErrStale, got %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"context"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/db"
+	localModels "github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ProjectionRepository stores the local projections of the orders and products of
+// other services, fed by their events
+type ProjectionRepository interface {
+	// ProcessOnce runs fn in a transaction, carried by the context given to fn, unless
+	// handler already processed the message. The message is recorded in the same
+	// transaction, so a failed fn leaves no trace and a redelivery is skipped.
+	ProcessOnce(ctx context.Context, messageID, handler string, fn func(ctx context.Context) error) error
+	// PurgeProcessed forgets the messages processed longer ago than retention and
+	// returns how many were forgotten
+	PurgeProcessed(ctx context.Context, retention time.Duration) (int64, error)
+
+	// CreateOrder stores a new order and links it to its customer
+	CreateOrder(ctx context.Context, order localModels.Order) error
+	// UpsertOrder creates the order, or updates the given columns of the stored one along
+	// with its customer, and links it to its customer in place of any previous one
+	UpsertOrder(ctx context.Context, order localModels.Order, columns []string) error
+	// DeleteOrder deletes an order and its link to its customer
+	DeleteOrder(ctx context.Context, orderID uint) error
+
+	// CreateProduct stores a new product
+	CreateProduct(ctx context.Context, productID uint) error
+	// SaveProduct creates or updates a product
+	SaveProduct(ctx context.Context, productID uint) error
+	// DeleteProduct deletes a product
+	DeleteProduct(ctx context.Context, productID uint) error
+}
+
+// GormProjectionRepository stores the projections in Postgres. Its methods join the
+// transaction carried by their context, see ProcessOnce.
+type GormProjectionRepository struct {
+	db *gorm.DB
+}
+
+// NewGormProjectionRepository creates a repository on db
+func NewGormProjectionRepository(db *gorm.DB) *GormProjectionRepository {
+	return &GormProjectionRepository{db: db}
+}
+
+// conn returns the connection to use for ctx, the current transaction if any
+func (r *GormProjectionRepository) conn(ctx context.Context) *gorm.DB {
+	return db.Conn(ctx, r.db)
+}
+
+func (r *GormProjectionRepository) ProcessOnce(ctx context.Context, messageID, handler string, fn func(ctx context.Context) error) error {
+	return r.conn(ctx).Transaction(func(tx *gorm.DB) error {
+		processed := localModels.ProcessedMessage{
+			MessageID:   messageID,
+			Handler:     handler,
+			ProcessedAt: time.Now(),
+		}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&processed)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			log.Printf("Message %s already processed by %s, skipping", messageID, handler)
+			return nil
+		}
+		return fn(db.WithTx(ctx, tx))
+	})
+}
+
+func (r *GormProjectionRepository) PurgeProcessed(ctx context.Context, retention time.Duration) (int64, error) {
+	result := r.conn(ctx).Where("processed_at < ?", time.Now().Add(-retention)).Delete(&localModels.ProcessedMessage{})
+	return result.RowsAffected, result.Error
+}
+
+func (r *GormProjectionRepository) CreateOrder(ctx context.Context, order localModels.Order) error {
+	tx := r.conn(ctx)
+	if err := tx.Create(&order).Error; err != nil {
+		return err
+	}
+	return tx.Create(&localModels.CustomerOrder{CustomerID: order.CustomerID, OrderID: order.ID}).Error
+}
+
+func (r *GormProjectionRepository) UpsertOrder(ctx context.Context, order localModels.Order, columns []string) error {
+	// The order may have moved to another customer
+	if !slices.Contains(columns, "customer_id") {
+		columns = append(slices.Clip(columns), "customer_id")
+	}
+
+	tx := r.conn(ctx)
+	err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns(columns),
+	}).Create(&order).Error
+	if err != nil {
+		return err
+	}
+
+	err = tx.Where("order_id = ? AND customer_id <> ?", order.ID, order.CustomerID).
+		Delete(&localModels.CustomerOrder{}).Error
+	if err != nil {
+		return err
+	}
+
+	customerOrder := localModels.CustomerOrder{CustomerID: order.CustomerID, OrderID: order.ID}
+	return tx.Where(&customerOrder).FirstOrCreate(&customerOrder).Error
+}
+
+func (r *GormProjectionRepository) DeleteOrder(ctx context.Context, orderID uint) error {
+	tx := r.conn(ctx)
+	if err := tx.Where("order_id = ?", orderID).Delete(&localModels.CustomerOrder{}).Error; err != nil {
+		return err
+	}
+	return tx.Delete(&localModels.Order{}, orderID).Error
+}
+
+func (r *GormProjectionRepository) CreateProduct(ctx context.Context, productID uint) error {
+	product := localModels.Product{}
+	product.ID = productID
+	return r.conn(ctx).Create(&product).Error
+}
+
+func (r *GormProjectionRepository) SaveProduct(ctx context.Context, productID uint) error {
+	product := localModels.Product{}
+	product.ID = productID
+	return r.conn(ctx).Save(&product).Error
+}
+
+func (r *GormProjectionRepository) DeleteProduct(ctx context.Context, productID uint) error {
+	return r.conn(ctx).Delete(&localModels.Product{}, productID).Error
+}
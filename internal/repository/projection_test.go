@@ -0,0 +1,119 @@
+package repository_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	localModels "github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/models"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/repository"
+)
+
+func TestGormProcessOnceSkipsProcessedMessage(t *testing.T) {
+	gormDB, mock := setupMockDB(t)
+	projections := repository.NewGormProjectionRepository(gormDB)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "processed_messages"`)).
+		WithArgs("msg-1", "order.created", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	err := projections.ProcessOnce(context.Background(), "msg-1", "order.created", func(ctx context.Context) error {
+		t.Error("expected an already processed message to be skipped")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func TestGormCreateOrderJoinsProcessOnce(t *testing.T) {
+	gormDB, mock := setupMockDB(t)
+	projections := repository.NewGormProjectionRepository(gormDB)
+
+	// The order and its link are written in the transaction recording the message
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "processed_messages"`)).
+		WithArgs("msg-1", "order.created", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "orders"`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(10))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "customer_orders"`)).
+		WithArgs(1, 10).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	order := localModels.Order{CustomerID: 1, Status: "paid"}
+	order.ID = 10
+	err := projections.ProcessOnce(context.Background(), "msg-1", "order.created", func(ctx context.Context) error {
+		return projections.CreateOrder(ctx, order)
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func TestGormUpsertOrderMovesCustomer(t *testing.T) {
+	gormDB, mock := setupMockDB(t)
+	projections := repository.NewGormProjectionRepository(gormDB)
+
+	// The order now belongs to customer 2, its link to customer 1 is replaced
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`ON CONFLICT ("id") DO UPDATE SET "status"="excluded"."status","customer_id"="excluded"."customer_id"`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(10))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "customer_orders" WHERE order_id = $1 AND customer_id <> $2`)).
+		WithArgs(10, 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "customer_orders" WHERE "customer_orders"."customer_id" = $1 AND "customer_orders"."order_id" = $2`)).
+		WithArgs(2, 10, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "customer_id", "order_id"}))
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "customer_orders"`)).
+		WithArgs(2, 10).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mock.ExpectCommit()
+
+	order := localModels.Order{CustomerID: 2, Status: "shipped"}
+	order.ID = 10
+	if err := projections.UpsertOrder(context.Background(), order, []string{"status"}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+// ago matches a time about age before now
+type ago struct{ age time.Duration }
+
+func (a ago) Match(v driver.Value) bool {
+	t, ok := v.(time.Time)
+	if !ok {
+		return false
+	}
+	drift := time.Since(t) - a.age
+	return drift >= 0 && drift < time.Second
+}
+
+func TestGormPurgeProcessed(t *testing.T) {
+	gormDB, mock := setupMockDB(t)
+	projections := repository.NewGormProjectionRepository(gormDB)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "processed_messages" WHERE processed_at < $1`)).
+		WithArgs(ago{72 * time.Hour}).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectCommit()
+
+	purged, err := projections.PurgeProcessed(context.Background(), 72*time.Hour)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if purged != 3 {
+		t.Errorf("expected 3 purged messages, got %d", purged)
+	}
+}
@@ -1,11 +1,13 @@
 package integration_test
 
 import (
+	"context"
 	"os"
 	"testing"
 	"time"
 
 	"github.com/PayeTonKawa-EPSI-2025/Common-V2/models"
+	database "github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/db"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -30,6 +32,15 @@ func ConnectDB(t *testing.T) *gorm.DB {
 		t.Fatalf("DB unreachable: %v", err)
 	}
 
+	// Bring the schema up to date as the migrate up command does
+	migrator, err := database.NewMigrator(db)
+	if err == nil {
+		err = migrator.Up(context.Background())
+	}
+	if err != nil {
+		t.Fatalf("failed to migrate DB: %v", err)
+	}
+
 	return db
 }
 
@@ -41,14 +52,10 @@ func ResetCustomersTable(t *testing.T, db *gorm.DB) {
 	}
 }
 
-// SeedDB creates the customers table if missing and inserts sample data.
+// SeedDB inserts sample data.
 func SeedDB(t *testing.T, db *gorm.DB) {
 	t.Helper()
 
-	if err := db.AutoMigrate(&models.Customer{}); err != nil {
-		t.Fatalf("failed to auto migrate: %v", err)
-	}
-
 	customers := []models.Customer{
 		{
 			Username:  "Alice",
@@ -0,0 +1,80 @@
+package integration_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/PayeTonKawa-EPSI-2025/Common-V2/models"
+	database "github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/db"
+	"gorm.io/gorm"
+)
+
+// The tables as AutoMigrate created them before versioned migrations, when orders and
+// products only mirrored the ids of the other services
+type baselineOrder struct {
+	gorm.Model
+}
+
+func (baselineOrder) TableName() string { return "orders" }
+
+type baselineProduct struct {
+	gorm.Model
+}
+
+func (baselineProduct) TableName() string { return "products" }
+
+type baselineCustomerOrder struct {
+	ID         uint `gorm:"primaryKey"`
+	CustomerID uint
+	Customer   models.Customer `gorm:"foreignKey:CustomerID"`
+	OrderID    uint
+	Order      baselineOrder `gorm:"foreignKey:OrderID"`
+}
+
+func (baselineCustomerOrder) TableName() string { return "customer_orders" }
+
+func TestMigrateBaselineSchema(t *testing.T) {
+	db := ConnectDB(t)
+	ctx := context.Background()
+
+	migrator, err := database.NewMigrator(db)
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := migrator.To(ctx, 0); err != nil {
+		t.Fatalf("failed to revert migrations: %v", err)
+	}
+	if err := db.AutoMigrate(&models.Customer{}, &baselineOrder{}, &baselineProduct{}, &baselineCustomerOrder{}); err != nil {
+		t.Fatalf("failed to create the baseline schema: %v", err)
+	}
+	if err := db.Create(&models.Customer{Username: "Alice"}).Error; err != nil {
+		t.Fatalf("failed to seed customer: %v", err)
+	}
+
+	if err := migrator.Up(ctx); err != nil {
+		t.Fatalf("failed to migrate the baseline schema: %v", err)
+	}
+
+	columns := map[string][]string{
+		"customers":     {"version"},
+		"orders":        {"customer_id", "status", "total", "ordered_at", "product_ids"},
+		"outbox_events": {"correlation_id", "failed_at"},
+	}
+	for table, names := range columns {
+		for _, name := range names {
+			if !db.Migrator().HasColumn(table, name) {
+				t.Errorf("expected column %s.%s to exist", table, name)
+			}
+		}
+	}
+
+	var version int
+	if err := db.Raw("SELECT version FROM customers WHERE username = ?", "Alice").Scan(&version).Error; err != nil {
+		t.Fatalf("failed to read customer version: %v", err)
+	}
+	if version != 1 {
+		t.Errorf("expected existing customers to start at version 1, got %d", version)
+	}
+
+	ResetCustomersTable(t, db)
+}
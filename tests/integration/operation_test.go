@@ -1,105 +1,122 @@
-package integration_test
-
-import (
-	"context"
-	"testing"
-
-	"github.com/PayeTonKawa-EPSI-2025/Common-V2/models"
-	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/dto"
-	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/operation"
-)
-
-func TestIntegration_GetCustomers(t *testing.T) {
-	db := ConnectDB(t)
-	ResetCustomersTable(t, db)
-	SeedDB(t, db)
-
-	resp, err := operation.GetCustomers(context.Background(), db)
-	if err != nil {
-		t.Fatalf("unexpected error: %v", err)
-	}
-
-	if len(resp.Body.Customers) != 2 {
-		t.Fatalf("expected 2 customers, got %d", len(resp.Body.Customers))
-	}
-}
-
-func TestIntegration_CreateCustomer(t *testing.T) {
-	db := ConnectDB(t)
-	ResetCustomersTable(t, db)
-
-	input := dto.CustomerCreateInput{
-		Body: dto.CustomerCreateBody{
-			Username:  "John",
-			FirstName: "John",
-			LastName:  "Doe",
-			Address: models.Address{
-				PostalCode: "75002",
-				City:       "Paris",
-			},
-			Company: models.Company{
-				CompanyName: "Created Corp",
-			},
-		},
-	}
-
-	resp, err := operation.CreateCustomer(context.Background(), db, nil, &input)
-	if err != nil {
-		t.Fatalf("unexpected error: %v", err)
-	}
-
-	if resp.Body.Username != "John" {
-		t.Fatalf("expected username John")
-	}
-}
-
-func TestIntegration_UpdateCustomer(t *testing.T) {
-	db := ConnectDB(t)
-	ResetCustomersTable(t, db)
-	SeedDB(t, db)
-
-	input := dto.CustomerCreateInput{
-		Body: dto.CustomerCreateBody{
-			Username:  "alice_updated",
-			FirstName: "Alice",
-			LastName:  "Smith",
-			Address: models.Address{
-				PostalCode: "75002",
-				City:       "Paris",
-			},
-			Company: models.Company{
-				CompanyName: "Updated Corp",
-			},
-		},
-	}
-
-	resp, err := operation.UpdateCustomer(context.Background(), db, nil, 1, input)
-	if err != nil {
-		t.Fatalf("unexpected error: %v", err)
-	}
-
-	if resp.Body.Username != "alice_updated" {
-		t.Fatalf("expected username 'alice_updated', got '%s'", resp.Body.Username)
-	}
-
-	if resp.Body.Address.City != "Paris" {
-		t.Fatalf("expected city 'Paris', got '%s'", resp.Body.Address.City)
-	}
-}
-
-func TestIntegration_DeleteCustomer(t *testing.T) {
-	db := ConnectDB(t)
-	ResetCustomersTable(t, db)
-	SeedDB(t, db)
-
-	err := operation.DeleteCustomer(context.Background(), db, nil, 1)
-	if err != nil {
-		t.Fatalf("unexpected error: %v", err)
-	}
-
-	// Ignore resp since we only care about the error
-	_, err = operation.GetCustomer(context.Background(), db, 1)
-	if err == nil {
-		t.Fatalf("expected not found after delete")
-	}
-}
+package integration_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/PayeTonKawa-EPSI-2025/Common-V2/models"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/dto"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/operation"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/orders"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/rabbitmq"
+	"github.com/PayeTonKawa-EPSI-2025/Customers-V2/internal/repository"
+	"gorm.io/gorm"
+)
+
+// newService returns a customer service on db, wired as in production
+func newService(db *gorm.DB) *operation.CustomerService {
+	return operation.NewCustomerService(
+		repository.NewGormCustomerRepository(db),
+		rabbitmq.NewOutboxPublisher(db),
+		orders.NewClient(orders.ConfigFromEnv()),
+		time.Now,
+		operation.NewEventID,
+	)
+}
+
+func TestIntegration_GetCustomers(t *testing.T) {
+	db := ConnectDB(t)
+	ResetCustomersTable(t, db)
+	SeedDB(t, db)
+
+	resp, err := newService(db).GetCustomers(context.Background(), &dto.CustomersListInput{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(resp.Body.Customers) != 2 {
+		t.Fatalf("expected 2 customers, got %d", len(resp.Body.Customers))
+	}
+}
+
+func TestIntegration_CreateCustomer(t *testing.T) {
+	db := ConnectDB(t)
+	ResetCustomersTable(t, db)
+
+	input := dto.CustomerCreateInput{
+		Body: dto.CustomerCreateBody{
+			Username:  "John",
+			FirstName: "John",
+			LastName:  "Doe",
+			Address: models.Address{
+				PostalCode: "75002",
+				City:       "Paris",
+			},
+			Company: models.Company{
+				CompanyName: "Created Corp",
+			},
+		},
+	}
+
+	resp, err := newService(db).CreateCustomer(context.Background(), &input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if resp.Body.Username != "John" {
+		t.Fatalf("expected username John")
+	}
+}
+
+func TestIntegration_UpdateCustomer(t *testing.T) {
+	db := ConnectDB(t)
+	ResetCustomersTable(t, db)
+	SeedDB(t, db)
+
+	input := dto.CustomerCreateInput{
+		Body: dto.CustomerCreateBody{
+			Username:  "alice_updated",
+			FirstName: "Alice",
+			LastName:  "Smith",
+			Address: models.Address{
+				PostalCode: "75002",
+				City:       "Paris",
+			},
+			Company: models.Company{
+				CompanyName: "Updated Corp",
+			},
+		},
+	}
+
+	resp, err := newService(db).UpdateCustomer(context.Background(), 1, nil, input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if resp.Body.Username != "alice_updated" {
+		t.Fatalf("expected username 'alice_updated', got '%s'", resp.Body.Username)
+	}
+
+	if resp.Body.Address.City != "Paris" {
+		t.Fatalf("expected city 'Paris', got '%s'", resp.Body.Address.City)
+	}
+}
+
+func TestIntegration_DeleteCustomer(t *testing.T) {
+	db := ConnectDB(t)
+	ResetCustomersTable(t, db)
+	SeedDB(t, db)
+
+	service := newService(db)
+	err := service.DeleteCustomer(context.Background(), 1, nil, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Ignore resp since we only care about the error
+	_, err = service.GetCustomer(context.Background(), 1, nil, false)
+	if err == nil {
+		t.Fatalf("expected not found after delete")
+	}
+}